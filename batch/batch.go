@@ -0,0 +1,147 @@
+// Package batch runs many tasks concurrently on one host.
+package batch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+)
+
+// Runner runs tasks through tugboat.Run, a bounded number at a time.
+//
+// Tasks are admitted in order: a task starts once there's a free slot
+// and enough of the host's capacity for the task's Resources.
+// A task which needs more than the whole capacity fails without running.
+type Runner struct {
+	// Stage is the shared parent stage. Each task is staged
+	// in its own directory under it.
+	Stage   *tug.Stage
+	Storage tug.Storage
+
+	// NewLogger returns the logger for a task.
+	NewLogger func(task *tug.Task) (tug.Logger, error)
+	// NewExecutor returns the executor for a task, logging to log.
+	NewExecutor func(log tug.Logger) (tug.Executor, error)
+
+	// Concurrency is the maximum number of tasks running at once.
+	// Defaults to one.
+	Concurrency int
+	// Capacity is the resources of the host shared by the tasks.
+	// Zero fields are unlimited.
+	Capacity tug.Resources
+}
+
+// Result is the outcome of one task of a batch.
+type Result struct {
+	Task *tug.Task
+	// Result is nil if the task didn't run.
+	Result     *tug.TaskResult
+	Err        error
+	Start, End time.Time
+}
+
+// Run runs the tasks and returns their results, in the same order.
+// When ctx is canceled, running tasks are canceled
+// and tasks which haven't started fail with a CanceledError.
+func (r *Runner) Run(ctx context.Context, tasks []*tug.Task) []*Result {
+	results := make([]*Result, len(tasks))
+	done := make(chan int)
+
+	concurrency := r.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var used tug.Resources
+	running := 0
+
+	// wait blocks until a running task finishes, and releases its resources.
+	wait := func() {
+		i := <-done
+		used = sub(used, tasks[i].Resources)
+		running--
+	}
+
+	ids := map[string]bool{}
+	for i, task := range tasks {
+		if ids[task.ID] {
+			results[i] = &Result{Task: task, Err: fmt.Errorf("duplicate task ID %q", task.ID)}
+			continue
+		}
+		ids[task.ID] = true
+
+		if !fits(task.Resources, r.Capacity) {
+			results[i] = &Result{Task: task, Err: fmt.Errorf("task needs more resources than the host's capacity")}
+			continue
+		}
+
+		for running > 0 && (running >= concurrency || !fits(add(used, task.Resources), r.Capacity)) {
+			wait()
+		}
+		if ctx.Err() != nil {
+			results[i] = &Result{Task: task, Err: &tug.CanceledError{}}
+			continue
+		}
+
+		used = add(used, task.Resources)
+		running++
+		go func(i int, task *tug.Task) {
+			results[i] = r.run(ctx, task)
+			done <- i
+		}(i, task)
+	}
+
+	for running > 0 {
+		wait()
+	}
+	return results
+}
+
+func (r *Runner) run(ctx context.Context, task *tug.Task) *Result {
+	res := &Result{Task: task, Start: time.Now()}
+	defer func() {
+		res.End = time.Now()
+	}()
+
+	log, err := r.NewLogger(task)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	exec, err := r.NewExecutor(log)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	res.Result, res.Err = tug.Run(ctx, task, r.Stage, log, r.Storage, exec)
+	return res
+}
+
+// fits returns true if req fits in capacity. Zero capacity fields are unlimited.
+func fits(req, capacity tug.Resources) bool {
+	return fitsField(req.CPUCores, capacity.CPUCores) &&
+		fitsField(req.RAMGB, capacity.RAMGB) &&
+		fitsField(req.DiskGB, capacity.DiskGB)
+}
+
+func fitsField(req, capacity float64) bool {
+	return capacity == 0 || req <= capacity
+}
+
+func add(a, b tug.Resources) tug.Resources {
+	return tug.Resources{
+		CPUCores: a.CPUCores + b.CPUCores,
+		RAMGB:    a.RAMGB + b.RAMGB,
+		DiskGB:   a.DiskGB + b.DiskGB,
+	}
+}
+
+func sub(a, b tug.Resources) tug.Resources {
+	return tug.Resources{
+		CPUCores: a.CPUCores - b.CPUCores,
+		RAMGB:    a.RAMGB - b.RAMGB,
+		DiskGB:   a.DiskGB - b.DiskGB,
+	}
+}
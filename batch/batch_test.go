@@ -0,0 +1,147 @@
+package batch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	"github.com/buchanae/tugboat/storage/local"
+)
+
+// fakeExec records the peak number of tasks and cores in use at once.
+// Tasks wait until want tasks are running, so that the peak doesn't
+// depend on timing. If that never happens, they're released after
+// a timeout, and the peak is lower than expected.
+type fakeExec struct {
+	want    int
+	release chan struct{}
+	once    sync.Once
+
+	mu             sync.Mutex
+	running, cores int
+	peak, peakCPU  int
+}
+
+func newFakeExec(want int) *fakeExec {
+	f := &fakeExec{want: want, release: make(chan struct{})}
+	time.AfterFunc(5*time.Second, f.open)
+	return f
+}
+
+func (f *fakeExec) open() {
+	f.once.Do(func() { close(f.release) })
+}
+
+func (f *fakeExec) Exec(ctx context.Context, task *tug.StagedTask, stdio *tug.Stdio) error {
+	f.mu.Lock()
+	f.running++
+	f.cores += int(task.Resources.CPUCores)
+	if f.running > f.peak {
+		f.peak = f.running
+	}
+	if f.cores > f.peakCPU {
+		f.peakCPU = f.cores
+	}
+	if f.running == f.want {
+		f.open()
+	}
+	f.mu.Unlock()
+
+	<-f.release
+
+	f.mu.Lock()
+	f.running--
+	f.cores -= int(task.Resources.CPUCores)
+	f.mu.Unlock()
+
+	if task.Command[0] == "fail" {
+		return &tug.ExecError{ExitCode: 3}
+	}
+	return nil
+}
+
+func newRunner(t *testing.T, exec *fakeExec) *Runner {
+	stage, err := tug.NewStage(t.TempDir(), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Runner{
+		Stage:   stage,
+		Storage: &local.Local{},
+		NewLogger: func(*tug.Task) (tug.Logger, error) {
+			return tug.EmptyLogger{Level: tug.ErrorLevel + 1}, nil
+		},
+		NewExecutor: func(tug.Logger) (tug.Executor, error) {
+			return exec, nil
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	exec := newFakeExec(2)
+	r := newRunner(t, exec)
+	r.Concurrency = 3
+	r.Capacity = tug.Resources{CPUCores: 4}
+
+	var tasks []*tug.Task
+	for i := 0; i < 8; i++ {
+		tasks = append(tasks, &tug.Task{
+			ID:        fmt.Sprintf("task-%d", i),
+			Command:   []string{"ok"},
+			Resources: tug.Resources{CPUCores: 2},
+		})
+	}
+	tasks[2].Command = []string{"fail"}
+	// Too big for the host.
+	tasks[5].Resources.CPUCores = 8
+
+	results := r.Run(context.Background(), tasks)
+
+	if exec.peakCPU > 4 {
+		t.Errorf("expected at most 4 cores in use, got %d", exec.peakCPU)
+	}
+	if exec.peak != 2 {
+		t.Errorf("expected 2 tasks to run at once, got %d", exec.peak)
+	}
+
+	for i, res := range results {
+		switch i {
+		case 2:
+			if res.Result == nil || res.Result.ExitCode != 3 {
+				t.Errorf("expected task 2 to exit with code 3")
+			}
+		case 5:
+			if res.Err == nil || res.Result != nil {
+				t.Errorf("expected task 5 to be rejected")
+			}
+		default:
+			if res.Err != nil {
+				t.Errorf("unexpected error for task %d: %s", i, res.Err)
+			}
+		}
+	}
+}
+
+func TestRunConcurrency(t *testing.T) {
+	exec := newFakeExec(2)
+	r := newRunner(t, exec)
+	r.Concurrency = 2
+
+	var tasks []*tug.Task
+	for i := 0; i < 6; i++ {
+		tasks = append(tasks, &tug.Task{ID: fmt.Sprintf("task-%d", i), Command: []string{"ok"}})
+	}
+	tasks = append(tasks, &tug.Task{ID: "task-0", Command: []string{"ok"}})
+
+	results := r.Run(context.Background(), tasks)
+
+	if exec.peak != 2 {
+		t.Errorf("expected 2 tasks to run at once, got %d", exec.peak)
+	}
+	if results[6].Err == nil {
+		t.Errorf("expected an error for a duplicate task ID")
+	}
+}
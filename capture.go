@@ -0,0 +1,220 @@
+package tugboat
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TailBuffer is an io.Writer which keeps only the last bytes written to it.
+// It's safe for concurrent use.
+type TailBuffer struct {
+	mu    sync.Mutex
+	size  int
+	buf   []byte
+	total int64
+}
+
+// NewTailBuffer returns a TailBuffer which keeps the last size bytes.
+func NewTailBuffer(size int) *TailBuffer {
+	return &TailBuffer{size: size}
+}
+
+func (t *TailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.total += int64(len(p))
+	if len(p) >= t.size {
+		t.buf = append(t.buf[:0], p[len(p)-t.size:]...)
+		return len(p), nil
+	}
+	if drop := len(t.buf) + len(p) - t.size; drop > 0 {
+		copy(t.buf, t.buf[drop:])
+		t.buf = t.buf[:len(t.buf)-drop]
+	}
+	t.buf = append(t.buf, p...)
+	return len(p), nil
+}
+
+// Truncated returns the number of bytes which were dropped.
+func (t *TailBuffer) Truncated() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.total - int64(len(t.buf))
+}
+
+// String returns the kept bytes, preceded by a truncation
+// marker if any bytes were dropped.
+func (t *TailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n := t.total - int64(len(t.buf)); n > 0 {
+		return strings.TrimPrefix(truncationMarker(n), "\n") + string(t.buf)
+	}
+	return string(t.buf)
+}
+
+// reset drops the kept bytes, returning them.
+func (t *TailBuffer) reset() ([]byte, int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	buf, truncated := t.buf, t.total-int64(len(t.buf))
+	t.buf, t.total = nil, 0
+	return buf, truncated
+}
+
+func truncationMarker(n int64) string {
+	return fmt.Sprintf("\n[tugboat: truncated %d bytes]\n", n)
+}
+
+// BoundedLogger wraps a Logger, limiting how much output of each stream
+// (stdout, stderr and service logs) reaches it. The first Head bytes of each
+// stream are forwarded, and only the last Tail bytes of the remainder are
+// forwarded when the task ends, after a truncation marker.
+//
+// Forwarded output is buffered and written at most once per FlushInterval,
+// so a tool writing many small chunks doesn't flood the logger. Buffered
+// output is written once the interval has passed, even if nothing more
+// is written to the stream.
+type BoundedLogger struct {
+	Logger
+	Head, Tail    int
+	FlushInterval time.Duration
+
+	mu      sync.Mutex
+	streams map[string]*boundedWriter
+}
+
+// NewBoundedLogger returns a BoundedLogger which forwards the first head
+// and last tail bytes of each stream to log.
+func NewBoundedLogger(log Logger, head, tail int) *BoundedLogger {
+	return &BoundedLogger{
+		Logger:        log,
+		Head:          head,
+		Tail:          tail,
+		FlushInterval: time.Second,
+		streams:       map[string]*boundedWriter{},
+	}
+}
+
+func (b *BoundedLogger) Stdout() io.Writer {
+	return b.stream("stdout", b.Logger.Stdout)
+}
+
+func (b *BoundedLogger) Stderr() io.Writer {
+	return b.stream("stderr", b.Logger.Stderr)
+}
+
+func (b *BoundedLogger) ServiceLog(name string) io.Writer {
+	return b.stream("service "+name, func() io.Writer {
+		return b.Logger.ServiceLog(name)
+	})
+}
+
+// EndTime flushes the buffered output of every stream
+// before passing the event on.
+func (b *BoundedLogger) EndTime(t time.Time) {
+	b.Flush()
+	b.Logger.EndTime(t)
+}
+
+// Flush writes the buffered head and the tail of every stream.
+func (b *BoundedLogger) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range b.streams {
+		s.flush()
+	}
+}
+
+// stream returns the writer for the named stream, so that
+// the bounds apply to the stream as a whole, however many
+// times it's requested.
+func (b *BoundedLogger) stream(name string, get func() io.Writer) io.Writer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s, ok := b.streams[name]; ok {
+		return s
+	}
+	w := get()
+	if w == nil {
+		return nil
+	}
+	s := &boundedWriter{
+		w:        w,
+		head:     b.Head,
+		tail:     NewTailBuffer(b.Tail),
+		interval: b.FlushInterval,
+	}
+	b.streams[name] = s
+	return s
+}
+
+type boundedWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	head     int
+	pending  []byte
+	tail     *TailBuffer
+	interval time.Duration
+	last     time.Time
+	// timer flushes the pending bytes when the interval has passed.
+	timer *time.Timer
+}
+
+func (s *boundedWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(p)
+	if n > s.head {
+		n = s.head
+	}
+	s.pending = append(s.pending, p[:n]...)
+	s.head -= n
+	s.tail.Write(p[n:])
+
+	wait := s.interval - time.Since(s.last)
+	switch {
+	case wait <= 0:
+		s.flushPending()
+	case len(s.pending) > 0 && s.timer == nil:
+		s.timer = time.AfterFunc(wait, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.flushPending()
+		})
+	}
+	return len(p), nil
+}
+
+// flush writes the pending head bytes and the tail.
+func (s *boundedWriter) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushPending()
+
+	buf, truncated := s.tail.reset()
+	if truncated > 0 {
+		io.WriteString(s.w, truncationMarker(truncated))
+	}
+	if len(buf) > 0 {
+		s.w.Write(buf)
+	}
+}
+
+func (s *boundedWriter) flushPending() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.last = time.Now()
+	if len(s.pending) == 0 {
+		return
+	}
+	s.w.Write(s.pending)
+	s.pending = nil
+}
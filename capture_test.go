@@ -0,0 +1,113 @@
+package tugboat
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestTailBuffer(t *testing.T) {
+	tests := []struct {
+		writes    []string
+		expected  string
+		truncated int64
+	}{
+		{nil, "", 0},
+		{[]string{"abc"}, "abc", 0},
+		{[]string{"abc", "de"}, "abcde", 0},
+		{[]string{"abc", "def"}, "[tugboat: truncated 1 bytes]\nbcdef", 1},
+		{[]string{"abcdefgh"}, "[tugboat: truncated 3 bytes]\ndefgh", 3},
+		{[]string{"ab", "cdefghij", "k"}, "[tugboat: truncated 6 bytes]\nghijk", 6},
+	}
+	for _, test := range tests {
+		b := NewTailBuffer(5)
+		for _, w := range test.writes {
+			if n, err := io.WriteString(b, w); n != len(w) || err != nil {
+				t.Fatalf("%q: unexpected write: %d, %v", test.writes, n, err)
+			}
+		}
+		if got := b.String(); got != test.expected {
+			t.Errorf("%q: unexpected string: %q", test.writes, got)
+		}
+		if got := b.Truncated(); got != test.truncated {
+			t.Errorf("%q: unexpected truncated bytes: %d", test.writes, got)
+		}
+	}
+}
+
+// bufferLogger collects stdout in a buffer.
+type bufferLogger struct {
+	EmptyLogger
+	out bytes.Buffer
+}
+
+func (b *bufferLogger) Stdout() io.Writer { return &b.out }
+
+func TestBoundedLogger(t *testing.T) {
+	tests := []struct {
+		writes []string
+		// head is what's forwarded before the end of the task,
+		// and expected is everything forwarded at the end.
+		head, expected string
+	}{
+		{[]string{"ab"}, "ab", "ab"},
+		{[]string{"abcd"}, "abcd", "abcd"},
+		{[]string{"abcdefg"}, "abcd", "abcdefg"},
+		{[]string{"abcd", "efgh"}, "abcd", "abcdefgh"},
+		{[]string{"ab", "cdef", "ghijklmn"}, "abcd", "abcd\n[tugboat: truncated 6 bytes]\nklmn"},
+	}
+	for _, test := range tests {
+		l := &bufferLogger{EmptyLogger: EmptyLogger{Level: ErrorLevel + 1}}
+		b := NewBoundedLogger(l, 4, 4)
+		b.FlushInterval = 0
+
+		for _, w := range test.writes {
+			// The bounds apply to the stream, however many times it's requested.
+			io.WriteString(b.Stdout(), w)
+		}
+		if got := l.out.String(); got != test.head {
+			t.Errorf("%q: unexpected head: %q", test.writes, got)
+		}
+		b.EndTime(time.Now())
+		if got := l.out.String(); got != test.expected {
+			t.Errorf("%q: unexpected output: %q", test.writes, got)
+		}
+	}
+}
+
+func TestBoundedLoggerFlushInterval(t *testing.T) {
+	writes := make(chan string, 10)
+	s := &boundedWriter{
+		w: writerFunc(func(p []byte) (int, error) {
+			writes <- string(p)
+			return len(p), nil
+		}),
+		head:     100,
+		tail:     NewTailBuffer(100),
+		interval: 20 * time.Millisecond,
+	}
+
+	// The first write is forwarded at once.
+	io.WriteString(s, "a")
+	if got := <-writes; got != "a" {
+		t.Fatalf("unexpected write: %q", got)
+	}
+
+	// Later writes within the interval are buffered,
+	// and forwarded together when the interval has passed.
+	start := time.Now()
+	io.WriteString(s, "b")
+	io.WriteString(s, "c")
+	select {
+	case got := <-writes:
+		if got != "bc" {
+			t.Errorf("unexpected write: %q", got)
+		}
+		if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+			t.Errorf("buffered output was forwarded after only %s", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("buffered output wasn't forwarded")
+	}
+}
@@ -0,0 +1,137 @@
+package tugboat
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// checkpointFile is the file in a task's stage directory which records
+// finished downloads, when Stage.Checkpoint is set.
+const checkpointFile = ".tugboat-checkpoint.jsonl"
+
+// Checkpoint records an input which finished downloading.
+type Checkpoint struct {
+	URL string `json:"url"`
+	// Path is the host path of the input.
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// SHA256 is the hex encoded checksum of the file's content.
+	SHA256 string `json:"sha256"`
+}
+
+// checkpoints is the set of finished downloads of a task.
+// New checkpoints are appended to the file as downloads finish,
+// so the file survives a crash part way through Download.
+type checkpoints struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]Checkpoint
+}
+
+// loadCheckpoints reads the checkpoint file in a task's stage directory.
+// A missing file means nothing was downloaded yet.
+func loadCheckpoints(dir string) (*checkpoints, error) {
+	c := &checkpoints{
+		path:    filepath.Join(dir, checkpointFile),
+		entries: map[string]Checkpoint{},
+	}
+
+	f, err := os.Open(c.path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, wrap(err, "failed to open checkpoint file")
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var cp Checkpoint
+		// A partly written line, e.g. after a crash, is skipped.
+		if err := json.Unmarshal(s.Bytes(), &cp); err != nil {
+			continue
+		}
+		c.entries[cp.Path] = cp
+	}
+	if err := s.Err(); err != nil {
+		return nil, wrap(err, "failed to read checkpoint file")
+	}
+	return c, nil
+}
+
+// done returns true if the file was downloaded from the same URL,
+// and is unchanged since: its size and checksum match the checkpoint.
+// Since the checksum is verified, a file which wasn't flushed to disk
+// before a crash is downloaded again.
+func (c *checkpoints) done(file File) bool {
+	c.mu.Lock()
+	cp, ok := c.entries[file.Path]
+	c.mu.Unlock()
+	if !ok || cp.URL != file.URL {
+		return false
+	}
+
+	info, err := os.Stat(file.Path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != cp.Size {
+		return false
+	}
+	sum, err := checksum(file.Path)
+	return err == nil && sum == cp.SHA256
+}
+
+// add records a finished download.
+func (c *checkpoints) add(file File) error {
+	info, err := os.Stat(file.Path)
+	if err != nil {
+		return err
+	}
+	sum, err := checksum(file.Path)
+	if err != nil {
+		return err
+	}
+	cp := Checkpoint{URL: file.URL, Path: file.Path, Size: info.Size(), SHA256: sum}
+	line, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, err := os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	c.entries[cp.Path] = cp
+	return nil
+}
+
+// checksum returns the hex encoded SHA-256 of a file's content.
+func checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
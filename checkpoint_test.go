@@ -0,0 +1,98 @@
+package tugboat
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// countingStorage copies local files, counting the downloads of each URL.
+type countingStorage struct {
+	mu   sync.Mutex
+	gets map[string]int
+}
+
+func (s *countingStorage) Get(ctx context.Context, url, abs string) error {
+	s.mu.Lock()
+	s.gets[url]++
+	s.mu.Unlock()
+
+	data, err := os.ReadFile(url)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(abs, data, 0644)
+}
+
+func (s *countingStorage) Put(ctx context.Context, url, rel, abs string) error {
+	return nil
+}
+func (s *countingStorage) SupportsGet(url string) bool { return true }
+func (s *countingStorage) SupportsPut(url string) bool { return true }
+
+func TestCheckpointedDownload(t *testing.T) {
+	src := t.TempDir()
+	var inputs []File
+	for _, name := range []string{"a", "b", "c"} {
+		p := filepath.Join(src, name)
+		if err := os.WriteFile(p, []byte("content of "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, File{URL: p, Path: "/inputs/" + name})
+	}
+
+	stage, err := NewStage(t.TempDir(), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stage.Checkpoint = true
+	task := &Task{ID: "resume", Inputs: inputs}
+	log := EmptyLogger{Level: ErrorLevel + 1}
+
+	download := func() *countingStorage {
+		staged, err := StageTask(stage, task)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store := &countingStorage{gets: map[string]int{}}
+		if err := Download(context.Background(), staged, store, log); err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+
+	download()
+
+	// Simulate an interrupted download of b, and a changed c.
+	dir := filepath.Join(stage.Dir, "resume", "inputs")
+	if err := os.WriteFile(filepath.Join(dir, "b"), []byte("cont"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "c"), []byte("CONTENT OF C"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := download()
+	var fetched []string
+	for url := range store.gets {
+		fetched = append(fetched, filepath.Base(url))
+	}
+	sort.Strings(fetched)
+	if strings.Join(fetched, ",") != "b,c" {
+		t.Errorf("expected only b and c to be downloaded again, got %v", fetched)
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "content of "+name {
+			t.Errorf("unexpected content of %s: %q", name, data)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	"github.com/buchanae/tugboat/batch"
+	"github.com/buchanae/tugboat/taskfile"
+)
+
+// batchCmd runs the tasks of JSON Lines files and directories of task
+// files concurrently, then prints a summary of the results.
+// All tasks are loaded and validated before any run.
+func batchCmd(args []string) error {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tug batch [flags] tasks.jsonl|dir ...")
+		fs.PrintDefaults()
+	}
+	rf := &runFlags{}
+	rf.register(fs)
+	pf := &paramFlags{}
+	pf.register(fs)
+	concurrency := fs.Int("concurrency", runtime.NumCPU(), "maximum number of tasks running at once")
+	cpus := fs.Float64("cpus", float64(runtime.NumCPU()), "CPU cores shared by the tasks; 0 is unlimited")
+	ramGB := fs.Float64("ram-gb", 0, "RAM in GB shared by the tasks; 0 is unlimited")
+	diskGB := fs.Float64("disk-gb", 0, "disk space in GB shared by the tasks; 0 is unlimited")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	params, err := pf.params()
+	if err != nil {
+		return err
+	}
+	tasks, err := loadTasks(fs.Args(), params)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := rf.newStorage()
+	if err != nil {
+		return err
+	}
+	stage, err := rf.newStage(rf.stageDir)
+	if err != nil {
+		return err
+	}
+
+	r := &batch.Runner{
+		Stage:   stage,
+		Storage: store,
+		NewLogger: func(task *tug.Task) (tug.Logger, error) {
+			return rf.newTaskLogger(os.Stderr, task.ID)
+		},
+		NewExecutor: rf.newExecutor,
+		Concurrency: *concurrency,
+		Capacity: tug.Resources{
+			CPUCores: *cpus,
+			RAMGB:    *ramGB,
+			DiskGB:   *diskGB,
+		},
+	}
+	results := r.Run(ctx, tasks)
+
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK\tSTATUS\tEXIT CODE\tDURATION\tERROR")
+	for _, res := range results {
+		status, code, duration := "ok", "-", "-"
+		if res.Result != nil {
+			code = fmt.Sprint(res.Result.ExitCode)
+			duration = res.End.Sub(res.Start).Round(time.Millisecond).String()
+		}
+		errMsg := ""
+		if res.Err != nil {
+			failed++
+			status = "failed"
+			if res.Result == nil {
+				status = "not run"
+			}
+			errMsg = res.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", res.Task.ID, status, code, duration, errMsg)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tasks failed", failed, len(results))
+	}
+	return nil
+}
+
+// loadTasks reads, substitutes and validates the tasks in the given
+// task files and directories, returning all the errors found.
+func loadTasks(args []string, params taskfile.Params) ([]*tug.Task, error) {
+	paths, err := taskPaths(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks []*tug.Task
+	var errs taskfile.ErrorList
+	for _, path := range paths {
+		files, err := readTaskFile(path)
+		if e, ok := err.(taskfile.ErrorList); ok {
+			errs = append(errs, e...)
+			continue
+		}
+		if e, ok := err.(*taskfile.Error); ok {
+			errs = append(errs, e)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			if err := f.Substitute(params); err != nil {
+				errs = append(errs, err.(taskfile.ErrorList)...)
+				continue
+			}
+			if err := f.Validate(); err != nil {
+				errs = append(errs, err.(taskfile.ErrorList)...)
+				continue
+			}
+			tasks = append(tasks, f.Task)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return tasks, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	"github.com/buchanae/tugboat/docker"
+	"github.com/buchanae/tugboat/history"
+	"github.com/buchanae/tugboat/kube"
+	"github.com/buchanae/tugboat/logger/jsonlog"
+	"github.com/buchanae/tugboat/storage/gs"
+	"github.com/buchanae/tugboat/storage/local"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// runFlags holds the flags shared by the commands which run tasks.
+type runFlags struct {
+	stageDir   string
+	leaveDir   bool
+	dryRun     bool
+	checkpoint bool
+
+	storage  string
+	gsBucket string
+
+	executor       string
+	kubeConfig     string
+	kubeNamespace  string
+	kubeStageClaim string
+	kubeStageRoot  string
+	stopSignal     string
+	stopTimeout    time.Duration
+
+	logFormat string
+	logLevel  string
+	logHead   int
+	logTail   int
+	history   string
+}
+
+func (f *runFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.stageDir, "stage", "tug-workdir", "directory where tasks are staged")
+	fs.BoolVar(&f.leaveDir, "leave-dir", false, "leave the stage directory in place when a task finishes")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "log the staging plan and container commands without running anything")
+	fs.BoolVar(&f.checkpoint, "checkpoint", false, "record finished downloads in the stage directory, so a rerun of a task skips them; use with -leave-dir")
+
+	fs.StringVar(&f.storage, "storage", "local", `storage backend: "local" or "gs"`)
+	fs.StringVar(&f.gsBucket, "gs-bucket", "", "Google Storage bucket, for the gs storage backend")
+
+	fs.StringVar(&f.executor, "executor", "docker", `executor: "docker", "podman" or "kube"`)
+	fs.StringVar(&f.kubeConfig, "kube-config", "", "path to a kubeconfig file; defaults to the in-cluster config")
+	fs.StringVar(&f.kubeNamespace, "kube-namespace", "default", "kubernetes namespace for task jobs")
+	fs.StringVar(&f.kubeStageClaim, "kube-stage-claim", "", "PersistentVolumeClaim holding the stage directory")
+	fs.StringVar(&f.kubeStageRoot, "kube-stage-root", "", "path where the stage claim is mounted on this host; defaults to the stage directory")
+	fs.StringVar(&f.stopSignal, "stop-signal", "", "signal sent to a task's container when it's canceled or times out; defaults to the image's stop signal (docker and podman only)")
+	fs.DurationVar(&f.stopTimeout, "stop-timeout", 0, "how long a stopped container has to exit before it's killed; defaults to 10s for docker and podman, and to the pod's grace period for kube")
+
+	fs.StringVar(&f.logFormat, "log-format", "text", `log format: "text" or "json"`)
+	fs.StringVar(&f.logLevel, "log-level", "info", "minimum level of text logs")
+	fs.IntVar(&f.logHead, "log-head", 1<<20, "bytes logged from the start of each output stream of a task; -1 logs all output")
+	fs.IntVar(&f.logTail, "log-tail", 1<<20, "bytes logged from the end of each output stream of a task, after the head")
+	fs.StringVar(&f.history, "history", "", "directory where task event histories, including task output, are recorded; off by default")
+}
+
+// newStage creates the stage directory for a task.
+// In a dry run, the directory isn't created.
+func (f *runFlags) newStage(dir string) (*tug.Stage, error) {
+	if f.dryRun {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		return &tug.Stage{Dir: abs, Mode: 0755, LeaveDir: f.leaveDir, DryRun: true}, nil
+	}
+
+	stage, err := tug.NewStage(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	stage.LeaveDir = f.leaveDir
+	stage.Checkpoint = f.checkpoint
+	return stage, nil
+}
+
+func (f *runFlags) newStorage() (tug.Storage, error) {
+	switch f.storage {
+	case "local":
+		return local.NewLocal()
+	case "gs":
+		if f.gsBucket == "" {
+			return nil, fmt.Errorf("the gs storage backend requires -gs-bucket")
+		}
+		return gs.NewGS(f.gsBucket)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", f.storage)
+	}
+}
+
+// newExecutor returns an executor which logs to log.
+func (f *runFlags) newExecutor(log tug.Logger) (tug.Executor, error) {
+	if f.stopTimeout < 0 {
+		return nil, fmt.Errorf("-stop-timeout must not be negative")
+	}
+	switch f.executor {
+	case "docker":
+		return &docker.Docker{Logger: log, StopSignal: f.stopSignal, StopTimeout: f.stopTimeout}, nil
+	case "podman":
+		d := docker.NewPodman(log)
+		d.StopSignal = f.stopSignal
+		d.StopTimeout = f.stopTimeout
+		return d, nil
+	case "kube":
+		return f.newKube(log)
+	default:
+		return nil, fmt.Errorf("unknown executor %q", f.executor)
+	}
+}
+
+func (f *runFlags) newKube(log tug.Logger) (*kube.Kube, error) {
+	if f.kubeStageClaim == "" {
+		return nil, fmt.Errorf("the kube executor requires -kube-stage-claim")
+	}
+	if f.stopSignal != "" {
+		return nil, fmt.Errorf("the kube executor doesn't support -stop-signal; set STOPSIGNAL in the image instead")
+	}
+
+	conf, err := clientcmd.BuildConfigFromFlags("", f.kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("loading kubernetes config: %s", err)
+	}
+	client, err := kubernetes.NewForConfig(conf)
+	if err != nil {
+		return nil, fmt.Errorf("creating kubernetes client: %s", err)
+	}
+
+	root := f.kubeStageRoot
+	if root == "" {
+		root = f.stageDir
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	return &kube.Kube{
+		Logger:    log,
+		Client:    client,
+		Namespace: f.kubeNamespace,
+		StageVolume: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: f.kubeStageClaim,
+			},
+		},
+		StageRoot:   root,
+		StopTimeout: f.stopTimeout,
+	}, nil
+}
+
+// newLogger returns a logger for a task. JSON logs are written to w.
+func (f *runFlags) newLogger(w io.Writer, taskID string) (tug.Logger, error) {
+	switch f.logFormat {
+	case "text":
+		level, err := tug.ParseLevel(f.logLevel)
+		if err != nil {
+			return nil, err
+		}
+		return tug.EmptyLogger{Level: level}, nil
+	case "json":
+		return jsonlog.NewLogger(w, taskID), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", f.logFormat)
+	}
+}
+
+// newTaskLogger returns a logger for a task, like newLogger, which also
+// records the task's events in its history, if a history directory
+// was given and this isn't a dry run. Unless -log-head is negative,
+// only the head and tail of each output stream are logged and recorded.
+func (f *runFlags) newTaskLogger(w io.Writer, taskID string) (tug.Logger, error) {
+	log, err := f.newLogger(w, taskID)
+	if err != nil {
+		return nil, err
+	}
+	if f.history != "" && !f.dryRun {
+		store, err := history.Open(f.history)
+		if err != nil {
+			return nil, err
+		}
+		log = store.Logger(taskID, log)
+	}
+	if f.logHead < 0 {
+		return log, nil
+	}
+	if f.logTail < 0 {
+		return nil, fmt.Errorf("-log-tail must not be negative")
+	}
+	return tug.NewBoundedLogger(log, f.logHead, f.logTail), nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/buchanae/tugboat/history"
+	"github.com/buchanae/tugboat/logger/jsonlog"
+)
+
+// inspectCmd prints the summary and event history of a task.
+func inspectCmd(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tug inspect [flags] -history dir task-id")
+		fs.PrintDefaults()
+	}
+	dir := fs.String("history", "", "directory of task histories, as given to -history of run, batch, worker or serve")
+	asJSON := fs.Bool("json", false, "print the events as newline-delimited JSON")
+	fs.Parse(args)
+
+	if *dir == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	id := fs.Arg(0)
+
+	store := &history.Store{Dir: *dir}
+	events, err := store.Events(id)
+	if err != nil {
+		return fmt.Errorf("%s: %s", id, err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range events {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	sum := history.Summarize(id, events)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "task:\t%s\n", sum.ID)
+	fmt.Fprintf(w, "state:\t%s\n", sum.State)
+	fmt.Fprintf(w, "runs:\t%d\n", sum.Runs)
+	if !sum.Start.IsZero() {
+		fmt.Fprintf(w, "started:\t%s\n", sum.Start.Local().Format(time.DateTime))
+	}
+	if !sum.End.IsZero() {
+		fmt.Fprintf(w, "ended:\t%s\n", sum.End.Local().Format(time.DateTime))
+	}
+	if sum.Error != "" {
+		fmt.Fprintf(w, "error:\t%s\n", sum.Error)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tEVENT\tDETAILS")
+	for _, e := range events {
+		ts := e.Time.Local().Format("15:04:05.000")
+		fmt.Fprintf(w, "%s\t%s\t%s\n", ts, e.Type, eventDetails(e))
+	}
+	return w.Flush()
+}
+
+// eventDetails formats the fields of an event which are set for its type.
+func eventDetails(e *jsonlog.Event) string {
+	switch e.Type {
+	case jsonlog.State:
+		return string(e.State)
+	case jsonlog.Meta:
+		return fmt.Sprintf("%s=%v", e.Key, e.Value)
+	case jsonlog.Version:
+		if e.Version != nil {
+			return e.Version.String()
+		}
+	case jsonlog.Log:
+		details := strings.ToUpper(e.Level) + " " + e.Msg
+		var keys []string
+		for k := range e.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			details += fmt.Sprintf(" %s=%v", k, e.Fields[k])
+		}
+		return details
+	case jsonlog.DownloadStarted, jsonlog.DownloadFinished, jsonlog.UploadStarted, jsonlog.UploadFinished:
+		if e.File == nil {
+			return ""
+		}
+		details := e.File.URL + " " + e.File.Path
+		if e.File.Size != 0 {
+			details += fmt.Sprintf(" (%d bytes)", e.File.Size)
+		}
+		return details
+	case jsonlog.Exited:
+		if e.ExitCode != nil {
+			return fmt.Sprintf("exit code %d", *e.ExitCode)
+		}
+	case jsonlog.Stdout, jsonlog.Stderr:
+		return fmt.Sprintf("%q", e.Data)
+	case jsonlog.ServiceLog:
+		return fmt.Sprintf("%s: %q", e.Service, e.Data)
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	"github.com/buchanae/tugboat/history"
+)
+
+// listCmd lists the tasks recorded in the history directory,
+// with the state of their latest run, oldest first.
+func listCmd(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tug list [flags] -history dir")
+		fs.PrintDefaults()
+	}
+	dir := fs.String("history", "", "directory of task histories, as given to -history of run, batch, worker or serve")
+	state := fs.String("state", "", "only list tasks in this state, e.g. RUNNING")
+	asJSON := fs.Bool("json", false, "print the tasks as JSON")
+	fs.Parse(args)
+
+	if *dir == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	store := &history.Store{Dir: *dir}
+	sums, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	filtered := []*history.Summary{}
+	for _, sum := range sums {
+		if *state == "" || sum.State == tug.State(*state) {
+			filtered = append(filtered, sum)
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(filtered)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK\tSTATE\tSTARTED\tDURATION\tRUNS")
+	for _, sum := range filtered {
+		started, duration := "-", "-"
+		if !sum.Start.IsZero() {
+			started = sum.Start.Local().Format(time.DateTime)
+		}
+		if !sum.End.IsZero() {
+			duration = sum.End.Sub(sum.Start).Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", sum.ID, sum.State, started, duration, sum.Runs)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/buchanae/tugboat/taskfile"
+)
+
+// paramFlags holds the flags which fill in "${params.NAME}"
+// placeholders in task files.
+type paramFlags struct {
+	file   string
+	values paramValues
+}
+
+func (p *paramFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&p.file, "params", "", "YAML or JSON file of task file parameters")
+	fs.Var(&p.values, "param", "task file parameter, as name=value; may be repeated, and overrides -params")
+}
+
+// params returns the params from the file, if any, overridden by -param flags.
+func (p *paramFlags) params() (taskfile.Params, error) {
+	params := taskfile.Params{}
+	if p.file != "" {
+		var err error
+		params, err = taskfile.LoadParams(p.file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for k, v := range p.values {
+		params[k] = v
+	}
+	return params, nil
+}
+
+// paramValues is a flag.Value collecting name=value pairs.
+type paramValues map[string]string
+
+func (v *paramValues) String() string {
+	var s []string
+	for k, val := range *v {
+		s = append(s, k+"="+val)
+	}
+	return strings.Join(s, ",")
+}
+
+func (v *paramValues) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected name=value, got %q", s)
+	}
+	if *v == nil {
+		*v = paramValues{}
+	}
+	(*v)[parts[0]] = parts[1]
+	return nil
+}
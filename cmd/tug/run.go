@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	tug "github.com/buchanae/tugboat"
+	"github.com/buchanae/tugboat/logger/jsonlog"
+	"github.com/buchanae/tugboat/taskfile"
+)
+
+// runCmd runs a single task from a task file. With -result, the task's
+// result is written to a file as JSON, apart from the logs and the task's
+// output. With -log-file, every event of the task, including all of its
+// output, is also written to a file as JSON. It's interrupted by SIGINT
+// or SIGTERM, which cancel the task, still uploading its outputs.
+// With -dry-run, only the plan is logged.
+func runCmd(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tug run [flags] task.yaml")
+		fs.PrintDefaults()
+	}
+	rf := &runFlags{}
+	rf.register(fs)
+	pf := &paramFlags{}
+	pf.register(fs)
+	resultPath := fs.String("result", "", "file to write the task's result to, as JSON")
+	logFile := fs.String("log-file", "", "file to write every event of the task to, as JSON, including output beyond -log-head and -log-tail")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	params, err := pf.params()
+	if err != nil {
+		return err
+	}
+	task, err := taskfile.Load(fs.Arg(0), params)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log, err := rf.newTaskLogger(os.Stderr, task.ID)
+	if err != nil {
+		return err
+	}
+	if *logFile != "" {
+		f, err := os.Create(*logFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		multi := tug.NewMultiLogger(log, jsonlog.NewLogger(f, task.ID))
+		// Deliver the buffered events before the file is closed.
+		defer multi.Close()
+		log = multi
+	}
+	store, err := rf.newStorage()
+	if err != nil {
+		return err
+	}
+	exec, err := rf.newExecutor(log)
+	if err != nil {
+		return err
+	}
+	stage, err := rf.newStage(rf.stageDir)
+	if err != nil {
+		return err
+	}
+
+	result, runErr := tug.Run(ctx, task, stage, log, store, exec)
+	if rf.dryRun || *resultPath == "" {
+		return runErr
+	}
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*resultPath, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return runErr
+}
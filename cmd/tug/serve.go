@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"syscall"
+
+	tug "github.com/buchanae/tugboat"
+	"github.com/buchanae/tugboat/server"
+	"github.com/buchanae/tugboat/server/tes"
+)
+
+// serveCmd runs an HTTP API for submitting and monitoring tasks,
+// and a GA4GH TES API under /ga4gh/tes/v1.
+// Task records, outputs and, unless -history is given, task histories
+// are kept in the data directory, so they survive restarts.
+// On SIGINT or SIGTERM, running tasks are canceled.
+//
+// The APIs are unauthenticated, and tasks can mount host paths, read and
+// write local files and run containers as root, so by default they're only
+// served on localhost. Put an authenticating proxy in front of them before
+// serving them on other interfaces.
+func serveCmd(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tug serve [flags]")
+		fs.PrintDefaults()
+	}
+	rf := &runFlags{}
+	rf.register(fs)
+	addr := fs.String("addr", "127.0.0.1:8000",
+		"address to serve the APIs on. The APIs are unauthenticated and run arbitrary containers, "+
+			"so only listen on other interfaces behind an authenticating proxy")
+	dataDir := fs.String("data", "tug-data", "directory holding the task database and task outputs")
+	concurrency := fs.Int("concurrency", runtime.NumCPU(), "maximum number of tasks running at once")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	historySet := false
+	fs.Visit(func(f *flag.Flag) {
+		historySet = historySet || f.Name == "history"
+	})
+	if !historySet {
+		rf.history = filepath.Join(*dataDir, "history")
+	}
+
+	if err := tug.EnsureDir(*dataDir, 0755); err != nil {
+		return err
+	}
+	db, err := server.OpenStore(filepath.Join(*dataDir, "tugboat.db"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	store, err := rf.newStorage()
+	if err != nil {
+		return err
+	}
+	stage, err := rf.newStage(rf.stageDir)
+	if err != nil {
+		return err
+	}
+	log, err := rf.newLogger(os.Stderr, "")
+	if err != nil {
+		return err
+	}
+
+	m := &server.Manager{
+		Store:     db,
+		Stage:     stage,
+		Storage:   store,
+		OutputDir: filepath.Join(*dataDir, "outputs"),
+		Log:       log,
+		NewLogger: func(task *tug.Task) (tug.Logger, error) {
+			return rf.newTaskLogger(os.Stderr, task.ID)
+		},
+		NewExecutor: rf.newExecutor,
+		Concurrency: *concurrency,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/v1/", server.NewHandler(m))
+	mux.Handle(tes.Prefix+"/", tes.NewHandler(m))
+	srv := &http.Server{Addr: *addr, Handler: mux}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		<-sigs
+		log.Info("shutting down")
+		cancel()
+		srv.Shutdown(context.Background())
+	}()
+
+	log.Info("server started", "addr", *addr, "data", *dataDir)
+	err = srv.ListenAndServe()
+	cancel()
+	m.Wait()
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+	log.Info("server stopped")
+	return err
+}
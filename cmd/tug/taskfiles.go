@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/buchanae/tugboat/taskfile"
+)
+
+// taskPaths expands directories in args to the task files
+// directly inside them.
+func taskPaths(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+
+		for _, pattern := range []string{"*.yaml", "*.yml", "*.json", "*.jsonl"} {
+			matches, err := filepath.Glob(filepath.Join(arg, pattern))
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, matches...)
+		}
+	}
+	return paths, nil
+}
+
+// readTaskFile reads the tasks in a task file. A ".jsonl" file holds
+// one task per line; other files hold a single task.
+func readTaskFile(path string) ([]*taskfile.File, error) {
+	if strings.HasSuffix(path, ".jsonl") {
+		return taskfile.ReadLines(path)
+	}
+	f, err := taskfile.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return []*taskfile.File{f}, nil
+}
@@ -1,52 +1,54 @@
 package main
 
 import (
-  "context"
-  "fmt"
-  tug "github.com/buchanae/tugboat"
-  "github.com/buchanae/tugboat/docker"
-  "github.com/buchanae/tugboat/storage/local"
+	"fmt"
+	"os"
+	"sort"
 )
 
+// commands maps subcommand names to their implementations.
+// Each is called with the arguments following the subcommand name.
+var commands = map[string]func(args []string) error{
+	"batch":    batchCmd,
+	"inspect":  inspectCmd,
+	"list":     listCmd,
+	"run":      runCmd,
+	"serve":    serveCmd,
+	"validate": validateCmd,
+	"version":  versionCmd,
+	"worker":   workerCmd,
+}
+
 func main() {
-  ctx := context.Background()
-  log := tug.EmptyLogger{}
-  store := &local.Local{}
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
 
-	stage, err := tug.NewStage("tug-workdir", 0755)
-  if err != nil {
-    panic(err)
-  }
-  //stage.LeaveDir = true
-  defer stage.RemoveAll()
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
 
-  exec := &docker.Docker{
-    Logger: log,
-  }
+	err := cmd(os.Args[2:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
 
-  task := &tug.Task{
-    ID: "test1",
-    ContainerImage: "alpine",
-    Command: []string{"md5sum", "/inputs/infile.txt"},
-    Stdout: "out.txt",
-    Inputs: []tug.File{
-      {
-        URL: "inputs/in.txt",
-        Path: "/inputs/infile.txt",
-      },
-    },
-    Outputs: []tug.File{
-      {
-        URL: "output",
-        Path: "out.txt",
-      },
-    },
-  }
+func usage() {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-  err = tug.Run(ctx, task, stage, log, store, exec)
-  if err != nil {
-    fmt.Println("RESULT", err)
-  } else {
-    fmt.Println("Success")
-  }
+	fmt.Fprintln(os.Stderr, "usage: tug <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+name)
+	}
 }
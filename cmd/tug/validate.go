@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/buchanae/tugboat/taskfile"
+)
+
+// validateCmd checks task files without running them, and prints
+// a diagnostic for each problem found. Directories are expanded
+// to the task files they contain. It fails if any diagnostic is an error.
+func validateCmd(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tug validate [flags] task.yaml|dir ...")
+		fs.PrintDefaults()
+	}
+	storage := fs.String("storage", "local", `storage backend the tasks will run with: "local" or "gs"`)
+	format := fs.String("format", "text", `output format: "text" or "json"`)
+	pf := &paramFlags{}
+	pf.register(fs)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	params, err := pf.params()
+	if err != nil {
+		return err
+	}
+	paths, err := taskPaths(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	var files []*taskfile.File
+	var diags []*taskfile.Diagnostic
+	for _, path := range paths {
+		tasks, err := readTaskFile(path)
+		switch e := err.(type) {
+		case nil:
+			files = append(files, tasks...)
+		case *taskfile.Error:
+			diags = append(diags, &taskfile.Diagnostic{Severity: taskfile.SeverityError, Error: e})
+		case taskfile.ErrorList:
+			for _, e := range e {
+				diags = append(diags, &taskfile.Diagnostic{Severity: taskfile.SeverityError, Error: e})
+			}
+		default:
+			return err
+		}
+	}
+
+	lint, err := taskfile.Lint(files, taskfile.LintOptions{
+		Storage: *storage,
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+	diags = append(diags, lint...)
+
+	if *format == "json" {
+		if diags == nil {
+			diags = []*taskfile.Diagnostic{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(diags); err != nil {
+			return err
+		}
+	} else {
+		for _, d := range diags {
+			fmt.Println(d)
+		}
+	}
+
+	errs := 0
+	for _, d := range diags {
+		if d.Severity == taskfile.SeverityError {
+			errs++
+		}
+	}
+	if errs > 0 {
+		return fmt.Errorf("found %d errors in %d task files", errs, len(paths))
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	tug "github.com/buchanae/tugboat"
+)
+
+func versionCmd(args []string) error {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the version as JSON")
+	fs.Parse(args)
+
+	v := tug.BuildVersion()
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	fmt.Println(v)
+	if v.Upstream != "" {
+		fmt.Println("upstream", v.Upstream)
+	}
+	return nil
+}
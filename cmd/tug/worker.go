@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	"github.com/buchanae/tugboat/logger/metrics"
+	"github.com/buchanae/tugboat/worker"
+	"github.com/buchanae/tugboat/worker/dirqueue"
+)
+
+// workerCmd runs a long-running worker which pulls tasks from a queue
+// directory. On SIGINT or SIGTERM, the worker drains: it stops claiming
+// tasks and waits for the running ones. A second signal, or the drain
+// timeout, cancels the running tasks.
+func workerCmd(args []string) error {
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tug worker [flags] -queue dir")
+		fs.PrintDefaults()
+	}
+	rf := &runFlags{}
+	rf.register(fs)
+	queueDir := fs.String("queue", "", "queue directory to pull tasks from")
+	concurrency := fs.Int("concurrency", runtime.NumCPU(), "maximum number of tasks running at once")
+	pollInterval := fs.Duration("poll-interval", time.Second, "how often to check the queue when it's empty")
+	drainTimeout := fs.Duration("drain-timeout", 0, "how long to wait for running tasks when draining before canceling them; 0 waits forever")
+	recoverTasks := fs.Bool("recover", false, "requeue tasks left running in the queue by a stopped worker; only use when no other worker shares the queue")
+	metricsAddr := fs.String("metrics-addr", "", `address to serve Prometheus metrics on at "/metrics", e.g. ":9090"`)
+	fs.Parse(args)
+
+	if *queueDir == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	q, err := dirqueue.New(*queueDir)
+	if err != nil {
+		return err
+	}
+	store, err := rf.newStorage()
+	if err != nil {
+		return err
+	}
+	stage, err := rf.newStage(rf.stageDir)
+	if err != nil {
+		return err
+	}
+	log, err := rf.newLogger(os.Stderr, "")
+	if err != nil {
+		return err
+	}
+
+	if *recoverTasks {
+		names, err := q.Recover()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			log.Info("requeued task", "file", name)
+		}
+	}
+
+	newLogger := func(task *tug.Task) (tug.Logger, error) {
+		return rf.newTaskLogger(os.Stderr, task.ID)
+	}
+
+	if *metricsAddr != "" {
+		collector := metrics.NewCollector()
+		newLogger = func(task *tug.Task) (tug.Logger, error) {
+			log, err := rf.newTaskLogger(os.Stderr, task.ID)
+			if err != nil {
+				return nil, err
+			}
+			return collector.Logger(log), nil
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", collector.Handler())
+		srv := &http.Server{Addr: *metricsAddr, Handler: mux}
+		go func() {
+			err := srv.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				log.Error("metrics server failed", "error", err)
+			}
+		}()
+		defer srv.Close()
+	}
+
+	w := &worker.Worker{
+		Source:       q,
+		Stage:        stage,
+		Storage:      store,
+		Log:          log,
+		NewLogger:    newLogger,
+		NewExecutor:  rf.newExecutor,
+		Concurrency:  *concurrency,
+		PollInterval: *pollInterval,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		<-sigs
+		log.Info("draining; send another signal to cancel running tasks")
+		w.Drain()
+
+		var timeout <-chan time.Time
+		if *drainTimeout > 0 {
+			timeout = time.After(*drainTimeout)
+		}
+		select {
+		case <-sigs:
+		case <-timeout:
+		case <-ctx.Done():
+			return
+		}
+		log.Info("canceling running tasks")
+		cancel()
+	}()
+
+	log.Info("worker started", "queue", *queueDir, "concurrency", *concurrency)
+	err = w.Run(ctx)
+	log.Info("worker stopped")
+	return err
+}
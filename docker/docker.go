@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	tug "github.com/buchanae/tugboat"
+	"math"
 	"os/exec"
 	"strings"
 	"time"
@@ -14,55 +15,64 @@ type Docker struct {
 	tug.Logger
 	LeaveContainer bool
 	NoPull         bool
-}
-
-func (d *Docker) Exec(ctx context.Context, task *tug.StagedTask, stdio *tug.Stdio) error {
-
-	if !d.NoPull {
-		pullErr := exec.Command("docker", "pull", task.ContainerImage).Run()
-		if pullErr != nil {
-			d.Info(`failed to pull container image "%s": %s`, task.ContainerImage, pullErr)
-		}
-	}
 
-	args := []string{"run", "-i", "--read-only"}
+	// Binary is the docker-compatible CLI used to run containers,
+	// e.g. "docker" or "podman". Defaults to "docker".
+	Binary string
+	// Dialect selects the flavor of CLI flags passed to Binary.
+	Dialect Dialect
+
+	// StopSignal is the signal sent to the container when the task
+	// is canceled or times out. Defaults to the image's stop signal (usually SIGTERM).
+	StopSignal string
+	// StopTimeout is how long to wait after StopSignal before
+	// killing the container. Defaults to 10 seconds.
+	StopTimeout time.Duration
+}
 
-	if !d.LeaveContainer {
-		args = append(args, "--rm")
+// NewPodman returns an executor which runs tasks with rootless podman.
+func NewPodman(log tug.Logger) *Docker {
+	return &Docker{
+		Logger:  log,
+		Binary:  "podman",
+		Dialect: PodmanDialect,
 	}
+}
 
-	for k, v := range task.Env {
-		args = append(args, "--env", fmt.Sprintf("%s=%s", k, v))
-	}
+// Dialect describes differences in the command line flags of
+// docker-compatible container CLIs.
+type Dialect int
+
+const (
+	// DockerDialect is the docker CLI.
+	DockerDialect Dialect = iota
+	// PodmanDialect is the podman CLI. Containers are run with --userns=keep-id,
+	// so that files written to the stage are owned by the worker's user
+	// instead of a subordinate uid from the user namespace.
+	PodmanDialect
+)
 
-	if task.Workdir != "" {
-		args = append(args, "--workdir", task.Workdir)
-	}
+func (d *Docker) Exec(ctx context.Context, task *tug.StagedTask, stdio *tug.Stdio) error {
 
 	name := fmt.Sprintf("task-%s-%s", task.ID, randString(5))
-	args = append(args, "--name", name)
-
-	for i, input := range task.Inputs {
-		host := input.Path
-		container := task.Task.Inputs[i].Path
-		arg := formatVolumeArg(host, container, true)
-		args = append(args, "-v", arg)
+	if task.DryRun {
+		return d.plan(task, name)
 	}
 
-	for i, host := range task.Volumes {
-		container := task.Task.Volumes[i]
-		arg := formatVolumeArg(host, container, false)
-		args = append(args, "-v", arg)
+	if !d.NoPull {
+		pullErr := d.command("pull", task.ContainerImage).Run()
+		if pullErr != nil {
+			d.Warn("failed to pull container image", "image", task.ContainerImage, "error", pullErr)
+		}
 	}
 
-	args = append(args, task.ContainerImage)
-	args = append(args, task.Command...)
+	args := d.runArgs(task, name)
 
 	// Roughly: `docker run --rm -i --read-only -w [workdir] -v [bindings] [imageName] [cmd]`
-	d.Meta("command", "docker "+strings.Join(args, " "))
+	d.Meta("command", d.binary()+" "+strings.Join(args, " "))
 	d.Meta("container name", name)
 
-	cmd := exec.Command("docker", args...)
+	cmd := d.command(args...)
 
 	cmd.Stdin = stdio.Stdin
 	cmd.Stdout = stdio.Stdout
@@ -72,7 +82,7 @@ func (d *Docker) Exec(ctx context.Context, task *tug.StagedTask, stdio *tug.Stdi
 
 	err = cmd.Start()
 	if err != nil {
-		return fmt.Errorf(`exec "docker run" failed: %s`, err)
+		return fmt.Errorf(`exec "%s run" failed: %s`, d.binary(), err)
 	}
 
 	cmdctx, cancel := context.WithCancel(ctx)
@@ -82,14 +92,14 @@ func (d *Docker) Exec(ctx context.Context, task *tug.StagedTask, stdio *tug.Stdi
 	// instead of expecting the os/exec signal to work.
 	go func() {
 		<-cmdctx.Done()
-		exec.Command("docker", "stop", "-t", "10", name).Run()
-		exec.Command("docker", "kill", name).Run()
+		d.command("stop", "-t", d.stopTimeout(), name).Run()
+		d.command("kill", name).Run()
 	}()
 
 	// Inspect the container for metadata
 	go func() {
 		ticker := time.NewTicker(time.Second)
-		cmd := exec.CommandContext(cmdctx, "docker", "inspect", name)
+		cmd := exec.CommandContext(cmdctx, d.binary(), "inspect", name)
 		for i := 0; i < 5; i++ {
 			select {
 			case <-cmdctx.Done():
@@ -109,7 +119,124 @@ func (d *Docker) Exec(ctx context.Context, task *tug.StagedTask, stdio *tug.Stdi
 		}
 	}()
 
-	return cmd.Wait()
+	// Sample resource usage while the container runs.
+	statsctx, stopStats := context.WithCancel(cmdctx)
+	usage := make(chan tug.ResourceUsage)
+	go func() {
+		usage <- d.sampleStats(statsctx, name, time.Second)
+	}()
+
+	err = cmd.Wait()
+
+	stopStats()
+	res := <-usage
+	d.Meta("resource usage", res)
+	if task.Result != nil {
+		task.Result.Resources = res
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		err = &tug.ExecError{ExitCode: exitErr.ExitCode()}
+	}
+
+	// Rootless podman maps the container's users into subordinate uids,
+	// so files created by a non-root user in the container can end up
+	// unreadable by the worker. Reclaim ownership of the volumes before
+	// the outputs are uploaded.
+	if d.Dialect == PodmanDialect {
+		for _, vol := range task.Volumes {
+			if rerr := d.reclaim(vol); rerr != nil {
+				d.Warn("failed to reclaim ownership of volume", "volume", vol, "error", rerr)
+			}
+		}
+	}
+
+	return err
+}
+
+// runArgs returns the arguments of the "run" command for the task's container.
+func (d *Docker) runArgs(task *tug.StagedTask, name string) []string {
+	args := []string{"run", "-i", "--read-only"}
+
+	if !d.LeaveContainer {
+		args = append(args, "--rm")
+	}
+
+	if d.Dialect == PodmanDialect {
+		args = append(args, "--userns=keep-id")
+	}
+
+	if d.StopSignal != "" {
+		args = append(args, "--stop-signal", d.StopSignal)
+	}
+
+	args = append(args, envArgs(task.Env)...)
+
+	if task.Workdir != "" {
+		args = append(args, "--workdir", task.Workdir)
+	}
+
+	if task.User != "" {
+		args = append(args, "--user", task.User)
+	}
+
+	if task.Network != "" {
+		args = append(args, "--network", task.Network)
+	}
+	for _, host := range task.ExtraHosts {
+		args = append(args, "--add-host", host)
+	}
+	for _, dns := range task.DNS {
+		args = append(args, "--dns", dns)
+	}
+	for _, port := range task.Ports {
+		args = append(args, "--publish", port)
+	}
+
+	args = append(args, "--name", name)
+	args = append(args, volumeArgs(task)...)
+
+	args = append(args, task.ContainerImage)
+	args = append(args, task.Command...)
+
+	return args
+}
+
+// plan logs the commands Exec would run for the task, without running them.
+func (d *Docker) plan(task *tug.StagedTask, name string) error {
+	args := d.runArgs(task, name)
+	d.Meta("command", d.binary()+" "+strings.Join(args, " "))
+	return nil
+}
+
+// reclaim changes ownership of the given host path (recursively)
+// back to the worker's user. In rootless podman, uid 0 inside
+// "podman unshare" is the user running podman.
+func (d *Docker) reclaim(path string) error {
+	out, err := d.command("unshare", "chown", "-R", "0:0", path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, out)
+	}
+	return nil
+}
+
+// stopTimeout returns StopTimeout as whole seconds, for "docker stop -t".
+// It's rounded up, since zero means the container is killed at once.
+func (d *Docker) stopTimeout() string {
+	if d.StopTimeout == 0 {
+		return "10"
+	}
+	return fmt.Sprint(int(math.Ceil(d.StopTimeout.Seconds())))
+}
+
+func (d *Docker) binary() string {
+	if d.Binary == "" {
+		return "docker"
+	}
+	return d.Binary
+}
+
+func (d *Docker) command(args ...string) *exec.Cmd {
+	return exec.Command(d.binary(), args...)
 }
 
 type ContainerMetadata struct {
@@ -0,0 +1,241 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+)
+
+// metaLogger records Meta events.
+type metaLogger struct {
+	tug.EmptyLogger
+	meta map[string]interface{}
+}
+
+func (m *metaLogger) Meta(key string, value interface{}) {
+	m.meta[key] = value
+}
+
+func TestDryRun(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "stage")
+	stage := &tug.Stage{Dir: dir, Mode: 0755, DryRun: true}
+
+	task := &tug.Task{
+		ID:             "task1",
+		ContainerImage: "alpine",
+		Command:        []string{"md5sum", "/inputs/in.txt"},
+		Env:            map[string]string{"B": "2", "A": "1"},
+		Volumes:        []string{"/outputs"},
+		Inputs:         []tug.File{{URL: "/data/in.txt", Path: "/inputs/in.txt"}},
+		Services:       []tug.Service{{Name: "db", ContainerImage: "postgres"}},
+	}
+	staged, err := tug.StageTask(stage, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := &metaLogger{meta: map[string]interface{}{}}
+	d := &Docker{Logger: log, Binary: "not-a-real-docker"}
+	stop, err := d.StartServices(context.Background(), staged)
+	stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.Exec(context.Background(), staged, &tug.Stdio{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected dry run not to create the stage directory")
+	}
+
+	name, _ := log.meta["network command"].(string)
+	if name == "" {
+		t.Fatalf("expected a network command, got %v", log.meta)
+	}
+	network := name[len("not-a-real-docker network create "):]
+	cmd, _ := log.meta["command"].(string)
+	fields := strings.Fields(cmd)
+	var container string
+	for i, f := range fields {
+		if f == "--name" && i+1 < len(fields) {
+			container = fields[i+1]
+		}
+	}
+
+	expected := map[string]interface{}{
+		"network command": name,
+		"service command": "not-a-real-docker run --detach --name " + network + "-db" +
+			" --network " + network + " --network-alias db" +
+			" -v " + dir + "/task1/inputs/in.txt:/inputs/in.txt:ro" +
+			" -v " + dir + "/task1/outputs:/outputs:rw postgres",
+		"command": "not-a-real-docker run -i --read-only --rm --env A=1 --env B=2" +
+			" --network " + network + " --name " + container +
+			" -v " + dir + "/task1/inputs/in.txt:/inputs/in.txt:ro" +
+			" -v " + dir + "/task1/outputs:/outputs:rw alpine md5sum /inputs/in.txt",
+	}
+	if !reflect.DeepEqual(log.meta, expected) {
+		t.Errorf("unexpected meta:\n%v\nexpected:\n%v", log.meta, expected)
+	}
+}
+
+func TestRunArgs(t *testing.T) {
+	podman := NewPodman(nil)
+	if podman.binary() != "podman" {
+		t.Errorf("unexpected podman binary: %s", podman.binary())
+	}
+
+	tests := []struct {
+		name     string
+		d        *Docker
+		task     tug.Task
+		expected []string
+	}{
+		{
+			name:     "docker",
+			d:        &Docker{},
+			expected: []string{"run", "-i", "--read-only", "--rm", "--name", "c1", "alpine", "ls"},
+		},
+		{
+			name:     "podman",
+			d:        podman,
+			expected: []string{"run", "-i", "--read-only", "--rm", "--userns=keep-id", "--name", "c1", "alpine", "ls"},
+		},
+		{
+			name: "podman with a user",
+			d:    podman,
+			task: tug.Task{User: "1000:1000"},
+			expected: []string{"run", "-i", "--read-only", "--rm", "--userns=keep-id",
+				"--user", "1000:1000", "--name", "c1", "alpine", "ls"},
+		},
+		{
+			name: "leave container and stop signal",
+			d:    &Docker{LeaveContainer: true, StopSignal: "SIGINT"},
+			task: tug.Task{Workdir: "/work"},
+			expected: []string{"run", "-i", "--read-only", "--stop-signal", "SIGINT",
+				"--workdir", "/work", "--name", "c1", "alpine", "ls"},
+		},
+		{
+			name:     "no network",
+			d:        &Docker{},
+			task:     tug.Task{Network: "none"},
+			expected: []string{"run", "-i", "--read-only", "--rm", "--network", "none", "--name", "c1", "alpine", "ls"},
+		},
+		{
+			name: "hosts, dns and ports",
+			d:    &Docker{},
+			task: tug.Task{
+				Network:    "tools",
+				ExtraHosts: []string{"db:10.0.0.2", "cache:10.0.0.3"},
+				DNS:        []string{"8.8.8.8"},
+				Ports:      []string{"8080:80", "9000"},
+			},
+			expected: []string{"run", "-i", "--read-only", "--rm", "--network", "tools",
+				"--add-host", "db:10.0.0.2", "--add-host", "cache:10.0.0.3", "--dns", "8.8.8.8",
+				"--publish", "8080:80", "--publish", "9000", "--name", "c1", "alpine", "ls"},
+		},
+	}
+	for _, test := range tests {
+		task := test.task
+		task.ID = "args"
+		task.ContainerImage = "alpine"
+		task.Command = []string{"ls"}
+		staged, err := tug.StageTask(&tug.Stage{Dir: t.TempDir(), DryRun: true}, &task)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := test.d.runArgs(staged, "c1"); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: unexpected args:\n%q\nexpected:\n%q", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	tests := map[time.Duration]string{
+		0:                       "10",
+		time.Millisecond:        "1",
+		time.Second:             "1",
+		1500 * time.Millisecond: "2",
+	}
+	for timeout, expected := range tests {
+		d := &Docker{StopTimeout: timeout}
+		if got := d.stopTimeout(); got != expected {
+			t.Errorf("unexpected stop timeout for %s: %s", timeout, got)
+		}
+	}
+}
+
+// fakeDocker writes a script which records the container commands
+// it's called with, and returns its path and the path of the record.
+func fakeDocker(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	record := filepath.Join(dir, "commands")
+	script := filepath.Join(dir, "docker")
+	content := "#!/bin/sh\n" +
+		"case \"$1\" in run|rm|network) echo \"$@\" >> " + record + ";; esac\n"
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return script, record
+}
+
+func TestServices(t *testing.T) {
+	binary, record := fakeDocker(t)
+	stage, err := tug.NewStage(t.TempDir(), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := &tug.Task{
+		ID: "task1",
+		Executors: []tug.TaskExecutor{
+			{ContainerImage: "alpine", Command: []string{"echo", "one"}},
+			{ContainerImage: "alpine", Command: []string{"echo", "two"}},
+		},
+		Services: []tug.Service{{Name: "db", ContainerImage: "postgres"}},
+	}
+	quiet := tug.EmptyLogger{Level: tug.ErrorLevel + 1}
+	d := &Docker{Logger: quiet, Binary: binary, NoPull: true}
+	if _, err := tug.Run(context.Background(), task, stage, quiet, nil, d); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The services are started once, before the first command,
+	// and removed after the last.
+	var commands []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case fields[0] == "network":
+			commands = append(commands, "network "+fields[1])
+		case fields[0] == "rm":
+			commands = append(commands, "rm")
+		case fields[1] == "--detach":
+			commands = append(commands, "run service")
+		default:
+			commands = append(commands, "run "+fields[len(fields)-1])
+		}
+	}
+	expected := []string{
+		"network create",
+		"run service",
+		"run one",
+		"run two",
+		"rm",
+		"network rm",
+	}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("unexpected commands: %q", commands)
+	}
+}
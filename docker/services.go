@@ -0,0 +1,191 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+)
+
+// StartServices starts the task's background service containers on a network
+// shared with the task's containers, and waits until they're healthy.
+// It's called by tugboat.Run once per task, so the services outlive each
+// of the task's commands.
+//
+// If the task doesn't name a custom network, a network is created for the task,
+// and task.Network is set to it. The returned stop function tears down the
+// services and the network, and must be called even when an error is returned.
+func (d *Docker) StartServices(ctx context.Context, task *tug.StagedTask) (func(), error) {
+	name := fmt.Sprintf("task-%s-%s", task.ID, randString(5))
+	if task.DryRun {
+		return func() {}, d.planServices(task, name)
+	}
+
+	network, stop, err := d.startServices(ctx, task, name)
+	if err == nil {
+		task.Network = network
+	}
+	return stop, err
+}
+
+// planServices logs the commands StartServices would run, without running them.
+func (d *Docker) planServices(task *tug.StagedTask, name string) error {
+	network, create, err := serviceNetwork(task, name)
+	if err != nil {
+		return err
+	}
+	if create {
+		d.Meta("network command", d.binary()+" network create "+network)
+	}
+	for _, svc := range task.Services {
+		args := d.serviceArgs(task, svc, name+"-"+svc.Name, network)
+		d.Meta("service command", d.binary()+" "+strings.Join(args, " "))
+	}
+	task.Network = network
+	return nil
+}
+
+// startServices starts the services, returning the network they're on.
+func (d *Docker) startServices(ctx context.Context, task *tug.StagedTask, name string) (string, func(), error) {
+	var stops []func()
+	stop := func() {
+		// Stop in reverse order, so the network is removed last.
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+	}
+
+	network, create, err := serviceNetwork(task, name)
+	if err != nil {
+		return "", stop, err
+	}
+	if create {
+		out, err := d.command("network", "create", network).CombinedOutput()
+		if err != nil {
+			return "", stop, fmt.Errorf("creating network %s: %s: %s", network, err, out)
+		}
+		stops = append(stops, func() {
+			d.command("network", "rm", network).Run()
+		})
+	}
+
+	for _, svc := range task.Services {
+		s, err := d.startService(task, svc, name+"-"+svc.Name, network)
+		if s != nil {
+			stops = append(stops, s.stop)
+		}
+		if err != nil {
+			return "", stop, fmt.Errorf("starting service %s: %s", svc.Name, err)
+		}
+	}
+
+	for _, svc := range task.Services {
+		err := d.waitHealthy(ctx, svc, name+"-"+svc.Name)
+		if err != nil {
+			return "", stop, fmt.Errorf("service %s: %s", svc.Name, err)
+		}
+	}
+	return network, stop, nil
+}
+
+// serviceNetwork returns the network the task's services and container
+// share, and whether it needs to be created for the task.
+func serviceNetwork(task *tug.StagedTask, name string) (string, bool, error) {
+	switch task.Network {
+	case "none":
+		return "", false, fmt.Errorf(`services can't be used with network "none"`)
+	case "", "bridge":
+		return name, true, nil
+	}
+	return task.Network, false, nil
+}
+
+type service struct {
+	d    *Docker
+	name string
+	logs *exec.Cmd
+}
+
+func (d *Docker) startService(task *tug.StagedTask, svc tug.Service, name, network string) (*service, error) {
+	if !d.NoPull {
+		pullErr := d.command("pull", svc.ContainerImage).Run()
+		if pullErr != nil {
+			d.Warn("failed to pull container image", "image", svc.ContainerImage, "error", pullErr)
+		}
+	}
+
+	args := d.serviceArgs(task, svc, name, network)
+	out, err := d.command(args...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", err, out)
+	}
+	d.Meta("service container", name)
+
+	s := &service{d: d, name: name}
+
+	// Follow the service's logs until the container is removed.
+	w := d.ServiceLog(svc.Name)
+	s.logs = d.command("logs", "--follow", name)
+	s.logs.Stdout = w
+	s.logs.Stderr = w
+	if err := s.logs.Start(); err != nil {
+		d.Warn("failed to follow service logs", "container", name, "error", err)
+		s.logs = nil
+	}
+	return s, nil
+}
+
+// serviceArgs returns the arguments of the "run" command for a service's container.
+func (d *Docker) serviceArgs(task *tug.StagedTask, svc tug.Service, name, network string) []string {
+	args := []string{"run", "--detach", "--name", name, "--network", network, "--network-alias", svc.Name}
+	if d.Dialect == PodmanDialect {
+		args = append(args, "--userns=keep-id")
+	}
+	args = append(args, envArgs(svc.Env)...)
+	args = append(args, volumeArgs(task)...)
+	args = append(args, svc.ContainerImage)
+	args = append(args, svc.Command...)
+	return args
+}
+
+func (s *service) stop() {
+	s.d.command("rm", "--force", s.name).Run()
+	if s.logs != nil {
+		s.logs.Wait()
+	}
+}
+
+// waitHealthy runs the service's health check in the container
+// until it succeeds, or until the service's health timeout.
+func (d *Docker) waitHealthy(ctx context.Context, svc tug.Service, name string) error {
+	if len(svc.HealthCheck) == 0 {
+		return nil
+	}
+
+	timeout := time.Duration(svc.HealthTimeout)
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	args := append([]string{"exec", name}, svc.HealthCheck...)
+	for {
+		err := exec.CommandContext(ctx, d.binary(), args...).Run()
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("health check failed after %s: %s", timeout, err)
+		case <-ticker.C:
+		}
+	}
+}
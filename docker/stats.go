@@ -0,0 +1,155 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+)
+
+// statsFormat is a "docker stats" template understood by both docker and podman.
+const statsFormat = "{{.MemUsage}}|{{.CPUPerc}}|{{.BlockIO}}|{{.NetIO}}"
+
+// sampleStats polls "docker stats" for the named container until ctx is done,
+// and returns the accumulated resource usage.
+//
+// "docker stats" reports CPU usage as a percentage, so CPU time is estimated
+// by integrating the percentage over the time between samples.
+func (d *Docker) sampleStats(ctx context.Context, name string, interval time.Duration) tug.ResourceUsage {
+	var usage tug.ResourceUsage
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return usage
+		case <-ticker.C:
+		}
+
+		out, err := exec.CommandContext(ctx, d.binary(), "stats", "--no-stream", "--format", statsFormat, name).Output()
+		if err != nil {
+			// The container might not be running yet, or might have exited.
+			continue
+		}
+
+		s, err := parseStats(string(out))
+		if err != nil {
+			d.Debug("failed to parse container stats", "container", name, "error", err)
+			continue
+		}
+
+		now := time.Now()
+		usage.CPUTime += time.Duration(s.cpuPercent / 100 * float64(now.Sub(last)))
+		last = now
+
+		if s.memory > usage.PeakMemory {
+			usage.PeakMemory = s.memory
+		}
+		// Block and network I/O are cumulative.
+		usage.BlockRead = s.blockRead
+		usage.BlockWrite = s.blockWrite
+		usage.NetRx = s.netRx
+		usage.NetTx = s.netTx
+	}
+}
+
+type stats struct {
+	memory                int64
+	cpuPercent            float64
+	blockRead, blockWrite int64
+	netRx, netTx          int64
+}
+
+// parseStats parses a line of "docker stats" output in statsFormat, e.g.
+// "2.1MiB / 7.7GiB|0.52%|4.1kB / 0B|1.2kB / 648B"
+func parseStats(line string) (*stats, error) {
+	fields := strings.Split(strings.TrimSpace(line), "|")
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("unexpected stats format: %q", line)
+	}
+
+	s := &stats{}
+	var err error
+
+	mem, _, err := parsePair(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	s.memory = mem
+
+	cpu := strings.TrimSuffix(strings.TrimSpace(fields[1]), "%")
+	s.cpuPercent, err = strconv.ParseFloat(cpu, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing cpu percent %q: %s", fields[1], err)
+	}
+
+	s.blockRead, s.blockWrite, err = parsePair(fields[2])
+	if err != nil {
+		return nil, err
+	}
+
+	s.netRx, s.netTx, err = parsePair(fields[3])
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parsePair parses a pair of sizes, e.g. "4.1kB / 0B".
+func parsePair(field string) (int64, int64, error) {
+	parts := strings.Split(field, "/")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("unexpected stats field: %q", field)
+	}
+	a, err := parseSize(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := parseSize(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return a, b, nil
+}
+
+var sizeUnits = []struct {
+	suffix string
+	mult   float64
+}{
+	// Longer suffixes first, so that "MiB" isn't matched as "B".
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"kB", 1e3},
+	{"KB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"TB", 1e12},
+	{"B", 1},
+}
+
+// parseSize parses a human readable size, e.g. "2.1MiB" or "4.1kB".
+func parseSize(size string) (int64, error) {
+	size = strings.TrimSpace(size)
+	if size == "--" {
+		return 0, nil
+	}
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(size, unit.suffix) {
+			num := strings.TrimSpace(strings.TrimSuffix(size, unit.suffix))
+			f, err := strconv.ParseFloat(num, 64)
+			if err != nil {
+				return 0, fmt.Errorf("parsing size %q: %s", size, err)
+			}
+			return int64(f * unit.mult), nil
+		}
+	}
+	return 0, fmt.Errorf("parsing size %q: unknown unit", size)
+}
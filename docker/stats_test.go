@@ -0,0 +1,29 @@
+package docker
+
+import (
+	"testing"
+)
+
+func TestParseStats(t *testing.T) {
+	s, err := parseStats("2MiB / 7.7GiB|50.00%|4.1kB / 0B|1.5kB / 648B\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := stats{
+		memory:     2 << 20,
+		cpuPercent: 50,
+		blockRead:  4100,
+		netRx:      1500,
+		netTx:      648,
+	}
+	if *s != expected {
+		t.Errorf("unexpected stats %+v, expected %+v", *s, expected)
+	}
+
+	if _, err := parseStats("2MiB / 7.7GiB|50.00%"); err == nil {
+		t.Error("expected error for missing fields")
+	}
+	if _, err := parseSize("12 parsecs"); err == nil {
+		t.Error("expected error for unknown unit")
+	}
+}
@@ -3,9 +3,47 @@ package docker
 import (
 	"fmt"
 	"math/rand"
+	"sort"
 	"time"
+
+	tug "github.com/buchanae/tugboat"
 )
 
+// volumeArgs returns the "-v" arguments which bind the staged
+// inputs (read-only) and volumes into the container.
+func volumeArgs(task *tug.StagedTask) []string {
+	var args []string
+
+	for i, input := range task.Inputs {
+		host := input.Path
+		container := task.Task.Inputs[i].Path
+		arg := formatVolumeArg(host, container, true)
+		args = append(args, "-v", arg)
+	}
+
+	for i, host := range task.Volumes {
+		container := task.Task.Volumes[i]
+		arg := formatVolumeArg(host, container, false)
+		args = append(args, "-v", arg)
+	}
+	return args
+}
+
+// envArgs returns the "--env" arguments for env, sorted by name.
+func envArgs(env map[string]string) []string {
+	var keys []string
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, k := range keys {
+		args = append(args, "--env", fmt.Sprintf("%s=%s", k, env[k]))
+	}
+	return args
+}
+
 func formatVolumeArg(host, container string, readonly bool) string {
 	mode := "rw"
 	if readonly {
@@ -0,0 +1,27 @@
+package tugboat
+
+import (
+	"time"
+)
+
+// Duration is a time.Duration which is written to task files and JSON
+// as a string, e.g. "1h30m", as parsed by time.ParseDuration.
+type Duration time.Duration
+
+// String returns the duration formatted like time.Duration.
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
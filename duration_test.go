@@ -0,0 +1,30 @@
+package tugboat
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDurationJSON(t *testing.T) {
+	task := &Task{ID: "t1", Timeout: Duration(90 * time.Second)}
+	data, err := json.Marshal(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"id":"t1","timeout":"1m30s","resources":{}}` {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+
+	decoded := &Task{}
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Timeout != task.Timeout {
+		t.Errorf("unexpected timeout: %s", decoded.Timeout)
+	}
+
+	if err := json.Unmarshal([]byte(`{"timeout": "soon"}`), decoded); err == nil {
+		t.Error("expected an error for an invalid duration")
+	}
+}
@@ -0,0 +1,323 @@
+// Package history records an append-only history of events for each task,
+// so tasks can be listed and inspected after they finish.
+//
+// Histories are kept in a directory, with one file of newline-delimited
+// JSON events (see package jsonlog) per task ID. Along with the Logger
+// events, the file records the task's state changes, as tracked by
+// tugboat.StateLogger. Each run of a task appends to its file, and the
+// task's state is that of its latest run.
+package history
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	"github.com/buchanae/tugboat/logger/jsonlog"
+)
+
+// ErrNotFound is returned for a task without a history.
+var ErrNotFound = errors.New("no history for task")
+
+const ext = ".jsonl"
+
+// Store is a directory of task histories.
+type Store struct {
+	Dir string
+}
+
+// Open returns the store in dir, creating the directory if needed.
+func Open(dir string) (*Store, error) {
+	if err := tug.EnsureDir(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{Dir: dir}, nil
+}
+
+func (s *Store) path(id string) (string, error) {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return "", errors.New("invalid task ID for a history file")
+	}
+	return filepath.Join(s.Dir, id+ext), nil
+}
+
+// Logger returns a Logger which passes every event on to log,
+// and records it, along with the task's state changes, in the
+// task's history. The history file is opened at the first event,
+// and closed at EndTime, which ends a run of the task.
+func (s *Store) Logger(id string, log tug.Logger) *tug.StateLogger {
+	r := &recorder{Logger: log, store: s, id: id}
+	return tug.NewStateLogger(r, func(from, to tug.State) {
+		r.record(func(l *jsonlog.Logger) { l.State(to) })
+	})
+}
+
+// Events returns the history of a task, oldest first.
+func (s *Store) Events(id string) ([]*jsonlog.Event, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []*jsonlog.Event
+	dec := jsonlog.NewDecoder(f)
+	for {
+		e, err := dec.Decode()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			// A partly written line, e.g. after a crash, ends the history.
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return events, nil
+			}
+			return nil, err
+		}
+		events = append(events, e)
+	}
+}
+
+// Summary describes the latest run of a task.
+type Summary struct {
+	ID    string    `json:"id"`
+	State tug.State `json:"state"`
+	Start time.Time `json:"start"`
+	// End is zero if the run didn't finish.
+	End time.Time `json:"end"`
+	// Runs is the number of times the task was run.
+	Runs int `json:"runs"`
+	// Error is the error the latest run failed with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Summarize describes the latest run in a task's history.
+func Summarize(id string, events []*jsonlog.Event) *Summary {
+	s := &Summary{ID: id, State: tug.Queued}
+	for _, e := range events {
+		switch e.Type {
+		case jsonlog.StartTime:
+			// A new run.
+			*s = Summary{ID: id, State: tug.Queued, Start: e.Time, Runs: s.Runs + 1}
+		case jsonlog.EndTime:
+			s.End = e.Time
+		case jsonlog.State:
+			s.State = e.State
+		case jsonlog.Finished:
+			s.State = e.State
+			s.Error = e.Error
+		}
+	}
+	return s
+}
+
+// Summary returns the summary of a task's latest run.
+func (s *Store) Summary(id string) (*Summary, error) {
+	events, err := s.Events(id)
+	if err != nil {
+		return nil, err
+	}
+	return Summarize(id, events), nil
+}
+
+// List returns the summaries of all tasks, by start time.
+func (s *Store) List() ([]*Summary, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var sums []*Summary
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		sum, err := s.Summary(strings.TrimSuffix(name, ext))
+		if err != nil {
+			return nil, err
+		}
+		sums = append(sums, sum)
+	}
+	sort.SliceStable(sums, func(i, j int) bool {
+		return sums[i].Start.Before(sums[j].Start)
+	})
+	return sums, nil
+}
+
+// recorder passes events on to a Logger, and records them
+// in a history file while the task runs.
+type recorder struct {
+	tug.Logger
+	store *Store
+	id    string
+
+	mu     sync.Mutex
+	file   *os.File
+	json   *jsonlog.Logger
+	failed bool
+}
+
+// record writes an event to the history, opening it if needed.
+func (r *recorder) record(f func(l *jsonlog.Logger)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.openLocked() {
+		f(r.json)
+	}
+}
+
+// openLocked opens the history file, if it isn't open,
+// and returns false if it couldn't be opened.
+func (r *recorder) openLocked() bool {
+	if r.json != nil {
+		return true
+	}
+	if r.failed {
+		return false
+	}
+
+	path, err := r.store.path(r.id)
+	var f *os.File
+	if err == nil {
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	}
+	if err != nil {
+		// Only report the error once.
+		r.failed = true
+		r.Logger.Error("failed to open task history", "task", r.id, "error", err)
+		return false
+	}
+	r.file = f
+	r.json = jsonlog.NewLogger(f, r.id)
+	return true
+}
+
+func (r *recorder) StartTime(t time.Time) {
+	r.Logger.StartTime(t)
+	r.record(func(l *jsonlog.Logger) { l.StartTime(t) })
+}
+
+// EndTime is the last event of a run, and closes the history file.
+func (r *recorder) EndTime(t time.Time) {
+	r.Logger.EndTime(t)
+	r.record(func(l *jsonlog.Logger) { l.EndTime(t) })
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return
+	}
+	if err := r.json.Err(); err != nil {
+		r.Logger.Error("failed to write task history", "task", r.id, "error", err)
+	}
+	r.file.Close()
+	r.file = nil
+	r.json = nil
+}
+
+func (r *recorder) Meta(key string, value interface{}) {
+	r.Logger.Meta(key, value)
+	r.record(func(l *jsonlog.Logger) { l.Meta(key, value) })
+}
+
+func (r *recorder) Version(v tug.Version) {
+	r.Logger.Version(v)
+	r.record(func(l *jsonlog.Logger) { l.Version(v) })
+}
+
+func (r *recorder) Debug(msg string, fields ...interface{}) {
+	r.Logger.Debug(msg, fields...)
+	r.record(func(l *jsonlog.Logger) { l.Debug(msg, fields...) })
+}
+
+func (r *recorder) Info(msg string, fields ...interface{}) {
+	r.Logger.Info(msg, fields...)
+	r.record(func(l *jsonlog.Logger) { l.Info(msg, fields...) })
+}
+
+func (r *recorder) Warn(msg string, fields ...interface{}) {
+	r.Logger.Warn(msg, fields...)
+	r.record(func(l *jsonlog.Logger) { l.Warn(msg, fields...) })
+}
+
+func (r *recorder) Error(msg string, fields ...interface{}) {
+	r.Logger.Error(msg, fields...)
+	r.record(func(l *jsonlog.Logger) { l.Error(msg, fields...) })
+}
+
+func (r *recorder) DownloadStarted(file tug.File) {
+	r.Logger.DownloadStarted(file)
+	r.record(func(l *jsonlog.Logger) { l.DownloadStarted(file) })
+}
+
+func (r *recorder) DownloadFinished(file tug.File) {
+	r.Logger.DownloadFinished(file)
+	r.record(func(l *jsonlog.Logger) { l.DownloadFinished(file) })
+}
+
+func (r *recorder) UploadStarted(file tug.File) {
+	r.Logger.UploadStarted(file)
+	r.record(func(l *jsonlog.Logger) { l.UploadStarted(file) })
+}
+
+func (r *recorder) UploadFinished(file tug.File) {
+	r.Logger.UploadFinished(file)
+	r.record(func(l *jsonlog.Logger) { l.UploadFinished(file) })
+}
+
+func (r *recorder) Running() {
+	r.Logger.Running()
+	r.record(func(l *jsonlog.Logger) { l.Running() })
+}
+
+func (r *recorder) Exited(exitCode int) {
+	r.Logger.Exited(exitCode)
+	r.record(func(l *jsonlog.Logger) { l.Exited(exitCode) })
+}
+
+func (r *recorder) Finished(state tug.State, err error) {
+	r.Logger.Finished(state, err)
+	r.record(func(l *jsonlog.Logger) { l.Finished(state, err) })
+}
+
+func (r *recorder) Stdout() io.Writer {
+	return r.stream(r.Logger.Stdout(), (*jsonlog.Logger).Stdout)
+}
+
+func (r *recorder) Stderr() io.Writer {
+	return r.stream(r.Logger.Stderr(), (*jsonlog.Logger).Stderr)
+}
+
+func (r *recorder) ServiceLog(name string) io.Writer {
+	return r.stream(r.Logger.ServiceLog(name), func(l *jsonlog.Logger) io.Writer {
+		return l.ServiceLog(name)
+	})
+}
+
+// stream returns a writer which writes to w,
+// and to the history's stream.
+func (r *recorder) stream(w io.Writer, hist func(*jsonlog.Logger) io.Writer) io.Writer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.openLocked() {
+		return w
+	}
+	if w == nil {
+		return hist(r.json)
+	}
+	return io.MultiWriter(w, hist(r.json))
+}
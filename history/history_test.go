@@ -0,0 +1,102 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	tug "github.com/buchanae/tugboat"
+	"github.com/buchanae/tugboat/logger/jsonlog"
+	"github.com/buchanae/tugboat/storage/local"
+)
+
+// fakeExec writes the command to stdout, and fails if it's "fail".
+type fakeExec struct{}
+
+func (fakeExec) Exec(ctx context.Context, task *tug.StagedTask, stdio *tug.Stdio) error {
+	fmt.Fprint(stdio.Stdout, task.Command[0])
+	if task.Command[0] == "fail" {
+		return &tug.ExecError{ExitCode: 1}
+	}
+	return nil
+}
+
+func run(t *testing.T, s *Store, id, cmd string) {
+	stage, err := tug.NewStage(t.TempDir(), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &tug.Task{ID: id, ContainerImage: "alpine", Command: []string{cmd}}
+	quiet := tug.EmptyLogger{Level: tug.ErrorLevel + 1}
+	tug.Run(context.Background(), task, stage, s.Logger(id, quiet), &local.Local{}, fakeExec{})
+}
+
+func TestHistory(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run(t, s, "ok", "ok")
+	run(t, s, "fail", "fail")
+
+	events, err := s.Events("ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var states []tug.State
+	var stdout string
+	for _, e := range events {
+		switch e.Type {
+		case jsonlog.State:
+			states = append(states, e.State)
+		case jsonlog.Stdout:
+			stdout += e.Data
+		}
+	}
+	expected := []tug.State{tug.Initializing, tug.Running, tug.Complete}
+	if !reflect.DeepEqual(states, expected) {
+		t.Errorf("unexpected states: %v", states)
+	}
+	if stdout != "ok" {
+		t.Errorf("unexpected stdout: %q", stdout)
+	}
+
+	sum, err := s.Summary("fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.State != tug.ExecutorError || sum.Error != "exit code 1" || sum.Runs != 1 {
+		t.Errorf("unexpected summary: %+v", sum)
+	}
+	if sum.Start.IsZero() || sum.End.Before(sum.Start) {
+		t.Errorf("unexpected times: %+v", sum)
+	}
+
+	// Running a task again appends to its history.
+	run(t, s, "fail", "ok")
+	sum, err = s.Summary("fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.State != tug.Complete || sum.Error != "" || sum.Runs != 2 {
+		t.Errorf("unexpected summary of the second run: %+v", sum)
+	}
+
+	sums, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, sum := range sums {
+		ids = append(ids, sum.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"ok", "fail"}) {
+		t.Errorf("unexpected tasks: %v", ids)
+	}
+
+	if _, err := s.Events("missing"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
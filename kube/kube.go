@@ -0,0 +1,371 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Kube is an executor which runs tasks as Kubernetes Jobs.
+//
+// The stage directory must be visible to both the worker and the pod,
+// e.g. through a shared PersistentVolumeClaim or a hostPath volume.
+// Kubernetes merges the container's stdout and stderr into a single
+// log stream, which is written to the task's stdout. The task's command
+// is passed as the container's args, so, as with docker, it's run by the
+// image's entrypoint, if any.
+type Kube struct {
+	tug.Logger
+	Client    kubernetes.Interface
+	Namespace string
+
+	// StageVolume is the volume holding the stage directory.
+	StageVolume corev1.VolumeSource
+	// StageRoot is the path where StageVolume is mounted on the worker.
+	// Staged paths are mounted into the pod as subpaths of StageVolume,
+	// relative to StageRoot.
+	StageRoot string
+
+	LeaveJob bool
+	// StopTimeout is the grace period given to the pod to exit
+	// after it's signaled to stop. Defaults to the pod's default (30 seconds).
+	StopTimeout time.Duration
+	// PollInterval controls how often the pod status is checked.
+	// Defaults to one second.
+	PollInterval time.Duration
+}
+
+func (k *Kube) Exec(ctx context.Context, task *tug.StagedTask, stdio *tug.Stdio) error {
+	if task.Stdin != "" {
+		return fmt.Errorf("stdin is not supported by the kubernetes executor")
+	}
+
+	job, err := k.job(task)
+	if err != nil {
+		return err
+	}
+
+	if task.DryRun {
+		spec, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		k.Meta("job", string(spec))
+		return nil
+	}
+
+	jobs := k.Client.BatchV1().Jobs(k.Namespace)
+	_, err = jobs.Create(ctx, job, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("creating job: %s", err)
+	}
+	k.Meta("job name", job.Name)
+
+	// Delete the job when finished, and always when the context
+	// is canceled. The context may be done already, so use a new one.
+	defer func() {
+		if k.LeaveJob && ctx.Err() == nil {
+			return
+		}
+		prop := metav1.DeletePropagationBackground
+		opts := metav1.DeleteOptions{PropagationPolicy: &prop}
+		err := jobs.Delete(context.Background(), job.Name, opts)
+		if err != nil {
+			k.Warn("failed to delete job", "job", job.Name, "error", err)
+		}
+	}()
+
+	pod, err := k.waitForPod(ctx, job.Name, started)
+	if err != nil {
+		return err
+	}
+	k.Meta("pod name", pod.Name)
+
+	err = k.streamLogs(ctx, pod.Name, stdio.Stdout)
+	if err != nil {
+		return err
+	}
+
+	pod, err = k.waitForPod(ctx, job.Name, terminated)
+	if err != nil {
+		return err
+	}
+
+	state := containerState(pod)
+	if state == nil || state.Terminated == nil {
+		return fmt.Errorf("pod %s has no terminated container", pod.Name)
+	}
+	if code := state.Terminated.ExitCode; code != 0 {
+		return &tug.ExecError{ExitCode: int(code)}
+	}
+	return nil
+}
+
+// job builds the Job spec for the given task.
+func (k *Kube) job(task *tug.StagedTask) (*batchv1.Job, error) {
+	var mounts []corev1.VolumeMount
+
+	for i, input := range task.Inputs {
+		sub, err := k.subPath(input.Path)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      "stage",
+			MountPath: task.Task.Inputs[i].Path,
+			SubPath:   sub,
+			ReadOnly:  true,
+		})
+	}
+
+	for i, host := range task.Volumes {
+		sub, err := k.subPath(host)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      "stage",
+			MountPath: task.Task.Volumes[i],
+			SubPath:   sub,
+		})
+	}
+
+	var keys []string
+	for key := range task.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var env []corev1.EnvVar
+	for _, key := range keys {
+		env = append(env, corev1.EnvVar{Name: key, Value: task.Env[key]})
+	}
+
+	switch task.Network {
+	case "", "bridge":
+	default:
+		return nil, fmt.Errorf("network %q is not supported by the kubernetes executor", task.Network)
+	}
+	if len(task.Services) > 0 {
+		return nil, fmt.Errorf("services are not supported by the kubernetes executor")
+	}
+	if len(task.Ports) > 0 {
+		return nil, fmt.Errorf("published ports are not supported by the kubernetes executor")
+	}
+
+	var aliases []corev1.HostAlias
+	for _, host := range task.ExtraHosts {
+		i := strings.Index(host, ":")
+		if i == -1 {
+			return nil, fmt.Errorf(`invalid extra host %q, expected "hostname:ip"`, host)
+		}
+		aliases = append(aliases, corev1.HostAlias{
+			Hostnames: []string{host[:i]},
+			IP:        host[i+1:],
+		})
+	}
+
+	var dns *corev1.PodDNSConfig
+	if len(task.DNS) > 0 {
+		dns = &corev1.PodDNSConfig{Nameservers: task.DNS}
+	}
+
+	var security *corev1.SecurityContext
+	if task.User != "" {
+		uid, gid, err := tug.ParseUser(task.User)
+		if err != nil {
+			return nil, err
+		}
+		u, g := int64(uid), int64(gid)
+		security = &corev1.SecurityContext{RunAsUser: &u, RunAsGroup: &g}
+	}
+
+	// The grace period is part of the pod spec, since deleting the job
+	// deletes its pods with their own grace period. It's rounded up
+	// to whole seconds, since zero means the pod is killed at once.
+	var grace *int64
+	if k.StopTimeout > 0 {
+		g := int64(math.Ceil(k.StopTimeout.Seconds()))
+		grace = &g
+	}
+
+	backoff := int32(0)
+	labels := map[string]string{"tugboat-task-id": labelValue(task.ID)}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   jobName(task.ID),
+			Labels: labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoff,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy:                 corev1.RestartPolicyNever,
+					TerminationGracePeriodSeconds: grace,
+					HostAliases:                   aliases,
+					DNSConfig:                     dns,
+					Containers: []corev1.Container{{
+						Name:            "task",
+						Image:           task.ContainerImage,
+						Args:            task.Command,
+						Env:             env,
+						WorkingDir:      task.Workdir,
+						VolumeMounts:    mounts,
+						SecurityContext: security,
+					}},
+					Volumes: []corev1.Volume{{
+						Name:         "stage",
+						VolumeSource: k.StageVolume,
+					}},
+				},
+			},
+		},
+	}, nil
+}
+
+// subPath returns the path of the given staged host path,
+// relative to the root of the stage volume.
+func (k *Kube) subPath(host string) (string, error) {
+	rel, err := filepath.Rel(k.StageRoot, host)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("staged path %s is not in the stage volume root %s", host, k.StageRoot)
+	}
+	return rel, nil
+}
+
+type podCondition func(*corev1.Pod) (bool, error)
+
+// waitForPod polls the pods of the given job until one satisfies cond.
+// A failed job, e.g. one whose pod couldn't be created or was deleted,
+// is returned as an error.
+func (k *Kube) waitForPod(ctx context.Context, jobName string, cond podCondition) (*corev1.Pod, error) {
+	interval := k.PollInterval
+	if interval == 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	pods := k.Client.CoreV1().Pods(k.Namespace)
+	opts := metav1.ListOptions{LabelSelector: "job-name=" + jobName}
+
+	for {
+		list, err := pods.List(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("listing pods for job %s: %s", jobName, err)
+		}
+		for i := range list.Items {
+			pod := &list.Items[i]
+			ok, err := cond(pod)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return pod, nil
+			}
+		}
+
+		job, err := k.Client.BatchV1().Jobs(k.Namespace).Get(ctx, jobName, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("getting job %s: %s", jobName, err)
+		}
+		for _, c := range job.Status.Conditions {
+			if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+				return nil, fmt.Errorf("job %s failed: %s: %s", jobName, c.Reason, c.Message)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (k *Kube) streamLogs(ctx context.Context, podName string, w io.Writer) error {
+	req := k.Client.CoreV1().Pods(k.Namespace).GetLogs(podName, &corev1.PodLogOptions{
+		Container: "task",
+		Follow:    true,
+	})
+	rc, err := req.Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("streaming logs for pod %s: %s", podName, err)
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(w, rc)
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("copying logs for pod %s: %s", podName, err)
+	}
+	return ctx.Err()
+}
+
+// started is true once the task container is running or has finished.
+// Image pull failures are returned as errors, since the pod would
+// otherwise wait forever.
+func started(pod *corev1.Pod) (bool, error) {
+	if err := podFailed(pod); err != nil {
+		return false, err
+	}
+	state := containerState(pod)
+	if state == nil {
+		return false, nil
+	}
+	if w := state.Waiting; w != nil {
+		switch w.Reason {
+		case "ErrImagePull", "ImagePullBackOff", "InvalidImageName":
+			return false, fmt.Errorf("pod %s failed to pull image: %s: %s", pod.Name, w.Reason, w.Message)
+		case "CreateContainerConfigError", "CreateContainerError":
+			return false, fmt.Errorf("pod %s failed to create container: %s: %s", pod.Name, w.Reason, w.Message)
+		}
+		return false, nil
+	}
+	return state.Running != nil || state.Terminated != nil, nil
+}
+
+func terminated(pod *corev1.Pod) (bool, error) {
+	if err := podFailed(pod); err != nil {
+		return false, err
+	}
+	state := containerState(pod)
+	return state != nil && state.Terminated != nil, nil
+}
+
+// podFailed returns an error if the pod finished without the task
+// container terminating, e.g. because it was evicted.
+func podFailed(pod *corev1.Pod) error {
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded, corev1.PodFailed:
+	default:
+		return nil
+	}
+	if state := containerState(pod); state != nil && state.Terminated != nil {
+		return nil
+	}
+	return fmt.Errorf("pod %s %s without running the task: %s: %s",
+		pod.Name, strings.ToLower(string(pod.Status.Phase)), pod.Status.Reason, pod.Status.Message)
+}
+
+func containerState(pod *corev1.Pod) *corev1.ContainerState {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == "task" {
+			return &status.State
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestExecExitCode(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	k := testKube(client)
+	stdout := &bytes.Buffer{}
+
+	go fakePod(t, client, 3)
+
+	err := k.Exec(context.Background(), testTask(), &tug.Stdio{Stdout: stdout})
+	execErr, ok := err.(*tug.ExecError)
+	if !ok {
+		t.Fatalf("expected ExecError, got %v", err)
+	}
+	if execErr.ExitCode != 3 {
+		t.Errorf("expected exit code 3, got %d", execErr.ExitCode)
+	}
+	if stdout.String() != "fake logs" {
+		t.Errorf("unexpected stdout: %q", stdout.String())
+	}
+
+	jobs, _ := client.BatchV1().Jobs("default").List(context.Background(), metav1.ListOptions{})
+	if len(jobs.Items) != 0 {
+		t.Errorf("expected job to be deleted")
+	}
+}
+
+func TestExecCancel(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	k := testKube(client)
+	k.LeaveJob = true
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		waitForJob(t, client)
+		cancel()
+	}()
+
+	err := k.Exec(ctx, testTask(), &tug.Stdio{Stdout: &bytes.Buffer{}})
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	jobs, _ := client.BatchV1().Jobs("default").List(context.Background(), metav1.ListOptions{})
+	if len(jobs.Items) != 0 {
+		t.Errorf("expected job to be deleted on cancel")
+	}
+}
+
+func TestExecPodFailed(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	k := testKube(client)
+
+	// The pod is evicted before the container starts.
+	go func() {
+		name := waitForJob(t, client)
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name + "-pod",
+				Labels: map[string]string{"job-name": name},
+			},
+			Status: corev1.PodStatus{
+				Phase:   corev1.PodFailed,
+				Reason:  "Evicted",
+				Message: "low on memory",
+			},
+		}
+		_, err := client.CoreV1().Pods("default").Create(context.Background(), pod, metav1.CreateOptions{})
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	err := k.Exec(context.Background(), testTask(), &tug.Stdio{Stdout: &bytes.Buffer{}})
+	if err == nil || !strings.Contains(err.Error(), "Evicted: low on memory") {
+		t.Errorf("expected an eviction error, got %v", err)
+	}
+}
+
+func TestExecJobFailed(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	k := testKube(client)
+
+	// The job fails without creating a pod.
+	go func() {
+		name := waitForJob(t, client)
+		jobs := client.BatchV1().Jobs("default")
+		job, err := jobs.Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{
+			Type:    batchv1.JobFailed,
+			Status:  corev1.ConditionTrue,
+			Reason:  "BackoffLimitExceeded",
+			Message: "Job has reached the specified backoff limit",
+		})
+		if _, err := jobs.UpdateStatus(context.Background(), job, metav1.UpdateOptions{}); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	err := k.Exec(context.Background(), testTask(), &tug.Stdio{Stdout: &bytes.Buffer{}})
+	if err == nil || !strings.Contains(err.Error(), "BackoffLimitExceeded") {
+		t.Errorf("expected a job failure, got %v", err)
+	}
+}
+
+func TestJobSpec(t *testing.T) {
+	k := testKube(nil)
+	job, err := k.job(testTask())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := job.Spec.Template.Spec.Containers[0]
+	if c.Image != "alpine" {
+		t.Errorf("unexpected image %q", c.Image)
+	}
+	if c.Command != nil || len(c.Args) != 2 || c.Args[0] != "md5sum" {
+		t.Errorf("expected the command as args, got %v %v", c.Command, c.Args)
+	}
+	if len(c.Env) != 2 || c.Env[0].Name != "A" || c.Env[1].Name != "B" {
+		t.Errorf("unexpected env %v", c.Env)
+	}
+
+	expected := []corev1.VolumeMount{
+		{Name: "stage", MountPath: "/inputs/in.txt", SubPath: "t1/inputs/in.txt", ReadOnly: true},
+		{Name: "stage", MountPath: "/outputs", SubPath: "t1/outputs"},
+	}
+	if len(c.VolumeMounts) != len(expected) {
+		t.Fatalf("unexpected mounts %v", c.VolumeMounts)
+	}
+	for i := range expected {
+		if c.VolumeMounts[i] != expected[i] {
+			t.Errorf("unexpected mount %v, expected %v", c.VolumeMounts[i], expected[i])
+		}
+	}
+
+	if job.Spec.Template.Spec.TerminationGracePeriodSeconds != nil {
+		t.Errorf("expected the default grace period")
+	}
+	// Sub-second grace periods are rounded up.
+	k.StopTimeout = 1500 * time.Millisecond
+	job, err = k.job(testTask())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := job.Spec.Template.Spec.TerminationGracePeriodSeconds; g == nil || *g != 2 {
+		t.Errorf("unexpected grace period %v", g)
+	}
+
+	task := testTask()
+	task.Volumes = []string{"/elsewhere/outputs"}
+	if _, err := k.job(task); err == nil {
+		t.Error("expected error for path outside of the stage root")
+	}
+}
+
+func testKube(client *fake.Clientset) *Kube {
+	return &Kube{
+		Logger:       tug.EmptyLogger{},
+		Client:       client,
+		Namespace:    "default",
+		StageRoot:    "/stage",
+		PollInterval: 10 * time.Millisecond,
+	}
+}
+
+func testTask() *tug.StagedTask {
+	return &tug.StagedTask{
+		Stage: &tug.Stage{Dir: "/stage/t1"},
+		Task: &tug.Task{
+			ID:             "t1",
+			ContainerImage: "alpine",
+			Command:        []string{"md5sum", "/inputs/in.txt"},
+			Env:            map[string]string{"B": "2", "A": "1"},
+			Inputs:         []tug.File{{URL: "in.txt", Path: "/inputs/in.txt"}},
+			Volumes:        []string{"/outputs"},
+		},
+		Inputs:  []tug.File{{URL: "in.txt", Path: "/stage/t1/inputs/in.txt"}},
+		Volumes: []string{"/stage/t1/outputs"},
+	}
+}
+
+func waitForJob(t *testing.T, client *fake.Clientset) string {
+	for i := 0; i < 100; i++ {
+		jobs, err := client.BatchV1().Jobs("default").List(context.Background(), metav1.ListOptions{})
+		if err == nil && len(jobs.Items) > 0 {
+			return jobs.Items[0].Name
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("timed out waiting for job")
+	return ""
+}
+
+// fakePod creates a terminated pod for the job, playing the part
+// of the job controller.
+func fakePod(t *testing.T, client *fake.Clientset, exitCode int32) {
+	name := waitForJob(t, client)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name + "-pod",
+			Labels: map[string]string{"job-name": name},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodFailed,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name: "task",
+				State: corev1.ContainerState{
+					Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode},
+				},
+			}},
+		},
+	}
+	_, err := client.CoreV1().Pods("default").Create(context.Background(), pod, metav1.CreateOptions{})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,45 @@
+package kube
+
+import (
+	"math/rand"
+	"strings"
+	"time"
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+var letterRunes = []rune("abcdefghijklmnopqrstuvwxyz0123456789")
+
+func randString(n int) string {
+	b := make([]rune, n)
+	for i := range b {
+		b[i] = letterRunes[rand.Intn(len(letterRunes))]
+	}
+	return string(b)
+}
+
+// jobName returns a unique, valid Kubernetes object name for the task ID.
+func jobName(id string) string {
+	name := labelValue(id)
+	if len(name) > 50 {
+		name = strings.Trim(name[:50], "-")
+	}
+	return "task-" + name + "-" + randString(5)
+}
+
+// labelValue converts the task ID to a valid Kubernetes label value.
+func labelValue(id string) string {
+	b := []rune(strings.ToLower(id))
+	for i, r := range b {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			b[i] = '-'
+		}
+	}
+	s := strings.Trim(string(b), "-")
+	if len(s) > 63 {
+		s = strings.Trim(s[:63], "-")
+	}
+	return s
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -14,20 +15,40 @@ type Logger interface {
 	Meta(key string, value interface{})
 	Version(Version)
 
-	Info(args ...interface{})
+	// Debug, Info, Warn and Error log a message with structured fields,
+	// given as alternating keys and values, e.g.
+	//   log.Warn("failed to pull image", "image", image, "error", err)
+	Debug(msg string, fields ...interface{})
+	Info(msg string, fields ...interface{})
+	Warn(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
 
 	DownloadStarted(file File)
 	DownloadFinished(file File)
 	UploadStarted(file File)
 	UploadFinished(file File)
 
+	// Running is called when a command starts, and Exited when it exits.
+	// Exited is given the command's exit code, or -1 if the command
+	// failed without one, e.g. if the container couldn't be started.
+	// These are called once for each executor of a multi-command task.
 	Running()
+	Exited(exitCode int)
+
+	// Finished is called once, before EndTime, with the task's final
+	// state, and the error the task failed with, if any.
+	Finished(state State, err error)
 
 	Stdout() io.Writer
 	Stderr() io.Writer
+
+	// ServiceLog returns a writer for the output of the named background service.
+	ServiceLog(name string) io.Writer
 }
 
 type EmptyLogger struct {
+	// Level is the minimum level of logged messages.
+	Level Level
 }
 
 func (e EmptyLogger) StartTime(t time.Time) {
@@ -42,8 +63,27 @@ func (e EmptyLogger) Meta(key string, value interface{}) {
 func (e EmptyLogger) Version(v Version) {
 	fmt.Println("Version", v)
 }
-func (e EmptyLogger) Info(args ...interface{}) {
-	fmt.Println(args...)
+func (e EmptyLogger) Debug(msg string, fields ...interface{}) {
+	e.log(DebugLevel, msg, fields)
+}
+func (e EmptyLogger) Info(msg string, fields ...interface{}) {
+	e.log(InfoLevel, msg, fields)
+}
+func (e EmptyLogger) Warn(msg string, fields ...interface{}) {
+	e.log(WarnLevel, msg, fields)
+}
+func (e EmptyLogger) Error(msg string, fields ...interface{}) {
+	e.log(ErrorLevel, msg, fields)
+}
+func (e EmptyLogger) log(level Level, msg string, fields []interface{}) {
+	if level < e.Level {
+		return
+	}
+	if len(fields) == 0 {
+		fmt.Println(level, msg)
+		return
+	}
+	fmt.Println(level, msg, FormatFields(fields...))
 }
 func (e EmptyLogger) DownloadStarted(file File) {
 	fmt.Println("DownloadStarted", file)
@@ -60,12 +100,84 @@ func (e EmptyLogger) UploadFinished(file File) {
 func (e EmptyLogger) Running() {
 	fmt.Println("Running")
 }
+func (e EmptyLogger) Exited(exitCode int) {
+	fmt.Println("Exited", exitCode)
+}
+func (e EmptyLogger) Finished(state State, err error) {
+	fmt.Println("Finished", state)
+}
 func (e EmptyLogger) Stdout() io.Writer {
 	return os.Stdout
 }
 func (e EmptyLogger) Stderr() io.Writer {
 	return os.Stderr
 }
+func (e EmptyLogger) ServiceLog(name string) io.Writer {
+	return os.Stderr
+}
+
+// Level is the severity of a log message.
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "DEBUG"
+	case InfoLevel:
+		return "INFO"
+	case WarnLevel:
+		return "WARN"
+	case ErrorLevel:
+		return "ERROR"
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// ParseLevel parses a level name, e.g. "info", case insensitively.
+func ParseLevel(s string) (Level, error) {
+	for l := DebugLevel; l <= ErrorLevel; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return 0, errf("unknown log level %q", s)
+}
+
+// FieldMap converts alternating keys and values to a map.
+// Keys which aren't strings are formatted with fmt.Sprint.
+// A trailing key without a value is kept under the key "EXTRA".
+func FieldMap(fields ...interface{}) map[string]interface{} {
+	m := map[string]interface{}{}
+	for i := 0; i < len(fields); i += 2 {
+		if i+1 == len(fields) {
+			m["EXTRA"] = fields[i]
+			break
+		}
+		m[fmt.Sprint(fields[i])] = fields[i+1]
+	}
+	return m
+}
+
+// FormatFields formats alternating keys and values as "key=value" pairs,
+// in the order given.
+func FormatFields(fields ...interface{}) string {
+	var parts []string
+	for i := 0; i < len(fields); i += 2 {
+		if i+1 == len(fields) {
+			parts = append(parts, fmt.Sprintf("EXTRA=%v", fields[i]))
+			break
+		}
+		parts = append(parts, fmt.Sprintf("%v=%v", fields[i], fields[i+1]))
+	}
+	return strings.Join(parts, " ")
+}
 
 type LogHelper struct {
 	Logger
@@ -83,12 +195,3 @@ func (d *LogHelper) Start() {
 func (d *LogHelper) Finish() {
 	d.Logger.EndTime(time.Now())
 }
-
-type Version struct {
-	Name, Doc                string
-	Major, Minor, Patch      int
-	Commit, Branch, Upstream string
-	Date                     time.Time
-}
-
-var version = Version{}
@@ -0,0 +1,355 @@
+// Package jsonlog implements a tugboat Logger which writes
+// newline-delimited JSON events, and a decoder to read them back.
+package jsonlog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+)
+
+// EventType identifies the Logger method which produced an event.
+type EventType string
+
+const (
+	StartTime        EventType = "start_time"
+	EndTime          EventType = "end_time"
+	Meta             EventType = "meta"
+	Version          EventType = "version"
+	Log              EventType = "log"
+	DownloadStarted  EventType = "download_started"
+	DownloadFinished EventType = "download_finished"
+	UploadStarted    EventType = "upload_started"
+	UploadFinished   EventType = "upload_finished"
+	Running          EventType = "running"
+	Exited           EventType = "exited"
+	Stdout           EventType = "stdout"
+	Stderr           EventType = "stderr"
+	ServiceLog       EventType = "service_log"
+	Finished         EventType = "finished"
+	// State events record a task's state changes. They have no
+	// Logger method; see Logger.State.
+	State EventType = "state"
+)
+
+// Event is one line of the log. Which fields are set depends on Type.
+type Event struct {
+	Time   time.Time `json:"time"`
+	TaskID string    `json:"task_id,omitempty"`
+	Type   EventType `json:"type"`
+
+	// File is set for download and upload events.
+	File *tug.File `json:"file,omitempty"`
+	// Key and Value are set for meta events. Value is written for
+	// meta events even when it's empty, e.g. 0 or "", and omitted
+	// for other events.
+	Key   string      `json:"key,omitempty"`
+	Value interface{} `json:"value"`
+	// Version is set for version events.
+	Version *tug.Version `json:"version,omitempty"`
+	// Level, Msg and Fields are set for log events.
+	Level  string                 `json:"level,omitempty"`
+	Msg    string                 `json:"msg,omitempty"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+	// Data is set for stdout, stderr and service log events.
+	Data string `json:"data,omitempty"`
+	// Service is the name of the service, for service log events.
+	Service string `json:"service,omitempty"`
+	// ExitCode is set for exited events.
+	ExitCode *int `json:"exit_code,omitempty"`
+	// State is set for state and finished events.
+	State tug.State `json:"state,omitempty"`
+	// Error is set for finished events of failed tasks.
+	Error string `json:"error,omitempty"`
+}
+
+// MarshalJSON writes Value only for meta events.
+func (e Event) MarshalJSON() ([]byte, error) {
+	type event Event
+	v := struct {
+		event
+		Value *interface{} `json:"value,omitempty"`
+	}{event: event(e)}
+	if e.Type == Meta {
+		v.Value = &e.Value
+	}
+	return json.Marshal(v)
+}
+
+// Logger is a tugboat Logger which writes each event as a line of JSON.
+// It's safe for concurrent use.
+type Logger struct {
+	TaskID string
+
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewLogger returns a Logger which writes events for the given task to w.
+func NewLogger(w io.Writer, taskID string) *Logger {
+	return &Logger{TaskID: taskID, w: w}
+}
+
+// Err returns the first error encountered while writing events, if any.
+// Events after an error are still written.
+func (l *Logger) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+func (l *Logger) write(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.TaskID = l.TaskID
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		// A value which can't be marshaled, e.g. a chan,
+		// is written in its fmt.Sprint form instead.
+		e.Value = sprintUnmarshalable(e.Value)
+		for k, v := range e.Fields {
+			e.Fields[k] = sprintUnmarshalable(v)
+		}
+		data, err = json.Marshal(e)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err == nil {
+		_, err = l.w.Write(append(data, '\n'))
+	}
+	if err != nil && l.err == nil {
+		l.err = err
+	}
+}
+
+// sprintUnmarshalable returns v, or its fmt.Sprint form
+// if it can't be marshaled to JSON.
+func sprintUnmarshalable(v interface{}) interface{} {
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return v
+}
+
+func (l *Logger) StartTime(t time.Time) {
+	l.write(Event{Type: StartTime, Time: t})
+}
+func (l *Logger) EndTime(t time.Time) {
+	l.write(Event{Type: EndTime, Time: t})
+}
+func (l *Logger) Meta(key string, value interface{}) {
+	l.write(Event{Type: Meta, Key: key, Value: value})
+}
+func (l *Logger) Version(v tug.Version) {
+	l.write(Event{Type: Version, Version: &v})
+}
+func (l *Logger) Debug(msg string, fields ...interface{}) {
+	l.log(tug.DebugLevel, msg, fields)
+}
+func (l *Logger) Info(msg string, fields ...interface{}) {
+	l.log(tug.InfoLevel, msg, fields)
+}
+func (l *Logger) Warn(msg string, fields ...interface{}) {
+	l.log(tug.WarnLevel, msg, fields)
+}
+func (l *Logger) Error(msg string, fields ...interface{}) {
+	l.log(tug.ErrorLevel, msg, fields)
+}
+func (l *Logger) log(level tug.Level, msg string, fields []interface{}) {
+	e := Event{Type: Log, Level: strings.ToLower(level.String()), Msg: msg}
+	if len(fields) > 0 {
+		e.Fields = tug.FieldMap(fields...)
+		// Errors don't marshal to JSON, so log their message instead.
+		for k, v := range e.Fields {
+			if err, ok := v.(error); ok {
+				e.Fields[k] = err.Error()
+			}
+		}
+	}
+	l.write(e)
+}
+func (l *Logger) DownloadStarted(file tug.File) {
+	l.write(Event{Type: DownloadStarted, File: &file})
+}
+func (l *Logger) DownloadFinished(file tug.File) {
+	l.write(Event{Type: DownloadFinished, File: &file})
+}
+func (l *Logger) UploadStarted(file tug.File) {
+	l.write(Event{Type: UploadStarted, File: &file})
+}
+func (l *Logger) UploadFinished(file tug.File) {
+	l.write(Event{Type: UploadFinished, File: &file})
+}
+func (l *Logger) Running() {
+	l.write(Event{Type: Running})
+}
+func (l *Logger) Exited(exitCode int) {
+	l.write(Event{Type: Exited, ExitCode: &exitCode})
+}
+func (l *Logger) Finished(state tug.State, err error) {
+	e := Event{Type: Finished, State: state}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	l.write(e)
+}
+func (l *Logger) Stdout() io.Writer {
+	return &streamWriter{l, Stdout, ""}
+}
+func (l *Logger) Stderr() io.Writer {
+	return &streamWriter{l, Stderr, ""}
+}
+func (l *Logger) ServiceLog(name string) io.Writer {
+	return &streamWriter{l, ServiceLog, name}
+}
+
+// State writes a state event, e.g. from a tugboat.StateLogger's OnChange.
+func (l *Logger) State(s tug.State) {
+	l.write(Event{Type: State, State: s})
+}
+
+// streamWriter writes an event for each chunk of output.
+type streamWriter struct {
+	l       *Logger
+	typ     EventType
+	service string
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	s.l.write(Event{Type: s.typ, Data: string(p), Service: s.service})
+	return len(p), nil
+}
+
+// Decoder reads events written by Logger.
+type Decoder struct {
+	dec *json.Decoder
+}
+
+// NewDecoder returns a Decoder which reads events from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{json.NewDecoder(r)}
+}
+
+// Decode returns the next event in the stream.
+// At the end of the stream, it returns io.EOF.
+func (d *Decoder) Decode() (*Event, error) {
+	e := &Event{}
+	err := d.dec.Decode(e)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Replay calls the Logger method of log which corresponds to the event.
+// Meta values are replayed as decoded from JSON, e.g. numbers as float64.
+// State events are skipped, since Logger has no method for them.
+//
+// If log is a *Logger, the event is written as is, keeping its original
+// time. Other loggers only receive the times of start and end events,
+// since the other Logger methods don't take a time.
+func Replay(e *Event, log tug.Logger) error {
+	if l, ok := log.(*Logger); ok {
+		l.write(*e)
+		return l.Err()
+	}
+
+	switch e.Type {
+	case StartTime:
+		log.StartTime(e.Time)
+	case EndTime:
+		log.EndTime(e.Time)
+	case Meta:
+		log.Meta(e.Key, e.Value)
+	case Version:
+		if e.Version != nil {
+			log.Version(*e.Version)
+		}
+	case Log:
+		replayLog(e, log)
+	case DownloadStarted:
+		log.DownloadStarted(file(e))
+	case DownloadFinished:
+		log.DownloadFinished(file(e))
+	case UploadStarted:
+		log.UploadStarted(file(e))
+	case UploadFinished:
+		log.UploadFinished(file(e))
+	case Running:
+		log.Running()
+	case Exited:
+		if e.ExitCode != nil {
+			log.Exited(*e.ExitCode)
+		}
+	case Stdout:
+		return writeString(log.Stdout(), e.Data)
+	case Stderr:
+		return writeString(log.Stderr(), e.Data)
+	case ServiceLog:
+		return writeString(log.ServiceLog(e.Service), e.Data)
+	case Finished:
+		var err error
+		if e.Error != "" {
+			err = errors.New(e.Error)
+		}
+		log.Finished(e.State, err)
+	case State:
+	default:
+		return fmt.Errorf("unknown event type %q", e.Type)
+	}
+	return nil
+}
+
+func replayLog(e *Event, log tug.Logger) {
+	var keys []string
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var fields []interface{}
+	for _, k := range keys {
+		fields = append(fields, k, e.Fields[k])
+	}
+
+	level, err := tug.ParseLevel(e.Level)
+	if err != nil {
+		level = tug.InfoLevel
+	}
+	switch level {
+	case tug.DebugLevel:
+		log.Debug(e.Msg, fields...)
+	case tug.InfoLevel:
+		log.Info(e.Msg, fields...)
+	case tug.WarnLevel:
+		log.Warn(e.Msg, fields...)
+	case tug.ErrorLevel:
+		log.Error(e.Msg, fields...)
+	}
+}
+
+func file(e *Event) tug.File {
+	if e.File == nil {
+		return tug.File{}
+	}
+	return *e.File
+}
+
+func writeString(w io.Writer, s string) error {
+	if w == nil {
+		return nil
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
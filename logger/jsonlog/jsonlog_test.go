@@ -0,0 +1,165 @@
+package jsonlog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+)
+
+func TestRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log := NewLogger(buf, "task-1")
+
+	start := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	file := tug.File{URL: "file:///data/in.txt", Path: "/stage/in.txt"}
+
+	log.StartTime(start)
+	log.Meta("hostname", "worker-1")
+	log.Meta("retries", 0)
+	log.Info("creating staging directory", "task", "task-1")
+	log.DownloadStarted(file)
+	fmt.Fprint(log.Stdout(), "hello\n")
+	fmt.Fprint(log.ServiceLog("db"), "ready\n")
+	if err := log.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 7 {
+		t.Fatalf("expected 7 lines, got %d", len(lines))
+	}
+
+	dec := NewDecoder(buf)
+	var events []*Event
+	for {
+		e, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.TaskID != "task-1" {
+			t.Errorf("unexpected task ID %q", e.TaskID)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("missing timestamp for %s", e.Type)
+		}
+		events = append(events, e)
+	}
+
+	var types []EventType
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	expected := []EventType{StartTime, Meta, Meta, Log, DownloadStarted, Stdout, ServiceLog}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("unexpected event types %v", types)
+	}
+
+	if !events[0].Time.Equal(start) {
+		t.Errorf("unexpected start time %s", events[0].Time)
+	}
+	if events[1].Key != "hostname" || events[1].Value != "worker-1" {
+		t.Errorf("unexpected meta event %+v", events[1])
+	}
+	// Empty meta values are kept.
+	if events[2].Key != "retries" || events[2].Value != float64(0) {
+		t.Errorf("unexpected meta event %+v", events[2])
+	}
+	if events[3].Level != "info" || events[3].Fields["task"] != "task-1" {
+		t.Errorf("unexpected log event %+v", events[3])
+	}
+	if *events[4].File != file {
+		t.Errorf("unexpected file %+v", events[4].File)
+	}
+	if events[6].Service != "db" || events[6].Data != "ready\n" {
+		t.Errorf("unexpected service log event %+v", events[6])
+	}
+
+	// Replaying into another JSON logger should produce the same events,
+	// with the same times.
+	replayed := &bytes.Buffer{}
+	log2 := NewLogger(replayed, "task-1")
+	for _, e := range events {
+		if err := Replay(e, log2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dec = NewDecoder(replayed)
+	for i := 0; ; i++ {
+		e, err := dec.Decode()
+		if err == io.EOF {
+			if i != len(events) {
+				t.Errorf("expected %d replayed events, got %d", len(events), i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < len(events) && !reflect.DeepEqual(e, events[i]) {
+			t.Errorf("unexpected replayed event %+v", e)
+		}
+	}
+}
+
+func TestValue(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log := NewLogger(buf, "task-1")
+	log.Meta("retries", 0)
+	log.Running()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	if !strings.Contains(lines[0], `"value":0`) {
+		t.Errorf("expected the meta value to be written: %s", lines[0])
+	}
+	if strings.Contains(lines[1], `"value"`) {
+		t.Errorf("expected no value for other events: %s", lines[1])
+	}
+}
+
+func TestUnmarshalableValue(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log := NewLogger(buf, "task-1")
+	log.Meta("chan", make(chan int))
+	log.Info("bad field", "chan", make(chan int), "n", 1)
+	log.Running()
+	if err := log.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewDecoder(buf)
+	var events []*Event
+	for {
+		e, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	if s, ok := events[0].Value.(string); !ok || !strings.HasPrefix(s, "0x") {
+		t.Errorf("expected the chan to be written with fmt.Sprint, got %v", events[0].Value)
+	}
+	if _, ok := events[1].Fields["chan"].(string); !ok || events[1].Fields["n"] != float64(1) {
+		t.Errorf("unexpected fields: %v", events[1].Fields)
+	}
+	if events[2].Type != Running {
+		t.Errorf("expected events after the bad value to be written, got %s", events[2].Type)
+	}
+}
@@ -0,0 +1,224 @@
+// Package metrics collects Prometheus metrics about tasks and file transfers
+// from tugboat Logger events.
+package metrics
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Collector holds the metrics of a worker. It's shared by all tasks;
+// call Logger to collect the events of each task.
+type Collector struct {
+	registry *prometheus.Registry
+
+	tasksStarted  prometheus.Counter
+	tasksFinished prometheus.Counter
+	tasksFailed   prometheus.Counter
+	tasksRunning  prometheus.Gauge
+	taskDuration  prometheus.Histogram
+	execDuration  prometheus.Histogram
+
+	// Labeled by direction ("download" or "upload") and URL scheme.
+	transferBytes    *prometheus.CounterVec
+	transferDuration *prometheus.HistogramVec
+}
+
+// NewCollector returns a Collector with its own registry.
+func NewCollector() *Collector {
+	c := &Collector{
+		registry: prometheus.NewRegistry(),
+		tasksStarted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "tugboat_tasks_started_total",
+			Help: "Number of tasks started.",
+		}),
+		tasksFinished: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "tugboat_tasks_finished_total",
+			Help: "Number of tasks finished, successfully or not.",
+		}),
+		tasksFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "tugboat_tasks_failed_total",
+			Help: "Number of tasks which finished with an error.",
+		}),
+		tasksRunning: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "tugboat_tasks_running",
+			Help: "Number of tasks currently running.",
+		}),
+		taskDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "tugboat_task_duration_seconds",
+			Help:    "Duration of tasks, from start to end, including transfers.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 18),
+		}),
+		execDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "tugboat_exec_duration_seconds",
+			Help:    "Duration of task commands.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 18),
+		}),
+		transferBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tugboat_transfer_bytes_total",
+			Help: "Bytes transferred by downloads and uploads.",
+		}, []string{"direction", "scheme"}),
+		transferDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "tugboat_transfer_duration_seconds",
+			Help:    "Duration of file downloads and uploads.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+		}, []string{"direction", "scheme"}),
+	}
+
+	c.registry.MustRegister(
+		c.tasksStarted,
+		c.tasksFinished,
+		c.tasksFailed,
+		c.tasksRunning,
+		c.taskDuration,
+		c.execDuration,
+		c.transferBytes,
+		c.transferDuration,
+	)
+	return c
+}
+
+// Registry returns the registry holding the collector's metrics.
+func (c *Collector) Registry() *prometheus.Registry {
+	return c.registry
+}
+
+// Handler returns an HTTP handler which serves the metrics,
+// e.g. at "/metrics".
+func (c *Collector) Handler() http.Handler {
+	return promhttp.HandlerFor(c.registry, promhttp.HandlerOpts{})
+}
+
+// Logger returns a Logger which collects metrics from the events
+// of a single task before passing them on to log.
+func (c *Collector) Logger(log tug.Logger) *Logger {
+	return &Logger{
+		Logger:    log,
+		c:         c,
+		transfers: map[string]time.Time{},
+	}
+}
+
+// Logger collects metrics from the events of a single task.
+// A task is counted as failed if its final state, given to Finished,
+// isn't Complete. Errors logged along the way, and commands which fail
+// with IgnoreError set, don't make a task fail.
+type Logger struct {
+	tug.Logger
+	c *Collector
+
+	mu        sync.Mutex
+	start     time.Time
+	execStart time.Time
+	failed    bool
+	transfers map[string]time.Time
+}
+
+func (l *Logger) StartTime(t time.Time) {
+	l.mu.Lock()
+	l.start = t
+	l.mu.Unlock()
+
+	l.c.tasksStarted.Inc()
+	l.c.tasksRunning.Inc()
+	l.Logger.StartTime(t)
+}
+
+func (l *Logger) EndTime(t time.Time) {
+	l.mu.Lock()
+	start, failed := l.start, l.failed
+	l.mu.Unlock()
+
+	l.c.tasksRunning.Dec()
+	l.c.tasksFinished.Inc()
+	if failed {
+		l.c.tasksFailed.Inc()
+	}
+	if !start.IsZero() {
+		l.c.taskDuration.Observe(t.Sub(start).Seconds())
+	}
+	l.Logger.EndTime(t)
+}
+
+func (l *Logger) Running() {
+	l.mu.Lock()
+	l.execStart = time.Now()
+	l.mu.Unlock()
+
+	l.Logger.Running()
+}
+
+func (l *Logger) Exited(exitCode int) {
+	l.mu.Lock()
+	if !l.execStart.IsZero() {
+		l.c.execDuration.Observe(time.Since(l.execStart).Seconds())
+		l.execStart = time.Time{}
+	}
+	l.mu.Unlock()
+
+	l.Logger.Exited(exitCode)
+}
+
+func (l *Logger) Finished(state tug.State, err error) {
+	l.mu.Lock()
+	l.failed = state != tug.Complete
+	l.mu.Unlock()
+
+	l.Logger.Finished(state, err)
+}
+
+func (l *Logger) DownloadStarted(file tug.File) {
+	l.startTransfer("download", file)
+	l.Logger.DownloadStarted(file)
+}
+
+func (l *Logger) DownloadFinished(file tug.File) {
+	l.finishTransfer("download", file)
+	l.Logger.DownloadFinished(file)
+}
+
+func (l *Logger) UploadStarted(file tug.File) {
+	l.startTransfer("upload", file)
+	l.Logger.UploadStarted(file)
+}
+
+func (l *Logger) UploadFinished(file tug.File) {
+	l.finishTransfer("upload", file)
+	l.Logger.UploadFinished(file)
+}
+
+func (l *Logger) startTransfer(direction string, file tug.File) {
+	l.mu.Lock()
+	l.transfers[direction+" "+file.Path] = time.Now()
+	l.mu.Unlock()
+}
+
+func (l *Logger) finishTransfer(direction string, file tug.File) {
+	key := direction + " " + file.Path
+	l.mu.Lock()
+	start, ok := l.transfers[key]
+	delete(l.transfers, key)
+	l.mu.Unlock()
+
+	scheme := urlScheme(file.URL)
+	l.c.transferBytes.WithLabelValues(direction, scheme).Add(float64(file.Size))
+	if ok {
+		l.c.transferDuration.WithLabelValues(direction, scheme).Observe(time.Since(start).Seconds())
+	}
+}
+
+// urlScheme returns the scheme of the URL, e.g. "gs".
+// Plain paths are treated as "file".
+func urlScheme(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" {
+		return "file"
+	}
+	return u.Scheme
+}
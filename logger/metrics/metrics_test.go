@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+
+	ok := c.Logger(tug.EmptyLogger{})
+	ok.StartTime(time.Now())
+	ok.DownloadStarted(tug.File{URL: "gs://bucket/in.txt", Path: "/stage/in.txt"})
+	ok.DownloadFinished(tug.File{URL: "gs://bucket/in.txt", Path: "/stage/in.txt", Size: 100})
+	ok.Running()
+	ok.Exited(0)
+	ok.UploadStarted(tug.File{URL: "/data/out.txt", Path: "/stage/out.txt"})
+	ok.UploadFinished(tug.File{URL: "/data/out.txt", Path: "/stage/out.txt", Size: 7})
+	ok.Finished(tug.Complete, nil)
+	ok.EndTime(time.Now())
+
+	// A failed command with IgnoreError, and a logged error,
+	// don't fail the task.
+	ignored := c.Logger(tug.EmptyLogger{})
+	ignored.StartTime(time.Now())
+	ignored.Running()
+	ignored.Exited(1)
+	ignored.Error("failed to pull container image")
+	ignored.Running()
+	ignored.Exited(0)
+	ignored.Finished(tug.Complete, nil)
+	ignored.EndTime(time.Now())
+
+	failed := c.Logger(tug.EmptyLogger{})
+	failed.StartTime(time.Now())
+	failed.Running()
+	failed.Exited(1)
+	failed.Finished(tug.ExecutorError, errors.New("exit code 1"))
+	failed.EndTime(time.Now())
+
+	checks := []struct {
+		name     string
+		got      float64
+		expected float64
+	}{
+		{"started", testutil.ToFloat64(c.tasksStarted), 3},
+		{"finished", testutil.ToFloat64(c.tasksFinished), 3},
+		{"failed", testutil.ToFloat64(c.tasksFailed), 1},
+		{"running", testutil.ToFloat64(c.tasksRunning), 0},
+		{"gs download bytes", testutil.ToFloat64(c.transferBytes.WithLabelValues("download", "gs")), 100},
+		{"file upload bytes", testutil.ToFloat64(c.transferBytes.WithLabelValues("upload", "file")), 7},
+	}
+	for _, check := range checks {
+		if check.got != check.expected {
+			t.Errorf("expected %s to be %v, but got %v", check.name, check.expected, check.got)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Package tracing implements a tugboat Logger which records an
+// OpenTelemetry trace for a task, with spans for each phase:
+// staging, each file download, each command, and each file upload.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Logger wraps a tugboat Logger, recording spans from the task's events
+// before passing the events on.
+//
+// The task span starts at StartTime and ends at EndTime. The staging span
+// covers the time until the first download or command starts.
+// Spans which are still open at EndTime, e.g. a failed download,
+// are ended with an error status.
+type Logger struct {
+	tug.Logger
+	tracer trace.Tracer
+	parent context.Context
+	taskID string
+
+	mu      sync.Mutex
+	ctx     context.Context
+	task    trace.Span
+	staging trace.Span
+	exec    trace.Span
+	execs   int
+	files   map[string]trace.Span
+}
+
+// NewLogger returns a Logger which records spans with the given tracer.
+// The task span is a child of any span in ctx.
+func NewLogger(ctx context.Context, tracer trace.Tracer, taskID string, log tug.Logger) *Logger {
+	return &Logger{
+		Logger: log,
+		tracer: tracer,
+		parent: ctx,
+		ctx:    ctx,
+		taskID: taskID,
+		files:  map[string]trace.Span{},
+	}
+}
+
+func (l *Logger) StartTime(t time.Time) {
+	l.mu.Lock()
+	l.ctx, l.task = l.tracer.Start(l.parent, "task",
+		trace.WithTimestamp(t),
+		trace.WithAttributes(attribute.String("task.id", l.taskID)),
+	)
+	_, l.staging = l.tracer.Start(l.ctx, "staging", trace.WithTimestamp(t))
+	l.mu.Unlock()
+
+	l.Logger.StartTime(t)
+}
+
+func (l *Logger) EndTime(t time.Time) {
+	l.mu.Lock()
+	l.endStaging()
+	if l.exec != nil {
+		l.exec.SetStatus(codes.Error, "command did not exit")
+		l.exec.End(trace.WithTimestamp(t))
+		l.exec = nil
+	}
+	for key, span := range l.files {
+		span.SetStatus(codes.Error, "transfer did not finish")
+		span.End(trace.WithTimestamp(t))
+		delete(l.files, key)
+	}
+	if l.task != nil {
+		l.task.End(trace.WithTimestamp(t))
+	}
+	l.mu.Unlock()
+
+	l.Logger.EndTime(t)
+}
+
+// Meta is recorded as an attribute of the task span.
+func (l *Logger) Meta(key string, value interface{}) {
+	l.mu.Lock()
+	if l.task != nil {
+		l.task.SetAttributes(attribute.String(key, fmt.Sprint(value)))
+	}
+	l.mu.Unlock()
+
+	l.Logger.Meta(key, value)
+}
+
+// Warn is recorded as an event on the task span.
+func (l *Logger) Warn(msg string, fields ...interface{}) {
+	l.event(tug.WarnLevel, msg, fields)
+	l.Logger.Warn(msg, fields...)
+}
+
+// Error is recorded as an event on the task span.
+func (l *Logger) Error(msg string, fields ...interface{}) {
+	l.event(tug.ErrorLevel, msg, fields)
+	l.Logger.Error(msg, fields...)
+}
+
+func (l *Logger) event(level tug.Level, msg string, fields []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.task == nil {
+		return
+	}
+	attrs := []attribute.KeyValue{attribute.String("level", level.String())}
+	for k, v := range tug.FieldMap(fields...) {
+		attrs = append(attrs, attribute.String(k, fmt.Sprint(v)))
+	}
+	l.task.AddEvent(msg, trace.WithAttributes(attrs...))
+}
+
+func (l *Logger) DownloadStarted(file tug.File) {
+	l.startFile("download", file)
+	l.Logger.DownloadStarted(file)
+}
+
+func (l *Logger) DownloadFinished(file tug.File) {
+	l.endFile("download", file)
+	l.Logger.DownloadFinished(file)
+}
+
+func (l *Logger) UploadStarted(file tug.File) {
+	l.startFile("upload", file)
+	l.Logger.UploadStarted(file)
+}
+
+func (l *Logger) UploadFinished(file tug.File) {
+	l.endFile("upload", file)
+	l.Logger.UploadFinished(file)
+}
+
+func (l *Logger) Running() {
+	l.mu.Lock()
+	l.endStaging()
+	_, l.exec = l.tracer.Start(l.ctx, "exec",
+		trace.WithAttributes(attribute.Int("executor.index", l.execs)),
+	)
+	l.execs++
+	l.mu.Unlock()
+
+	l.Logger.Running()
+}
+
+func (l *Logger) Exited(exitCode int) {
+	l.mu.Lock()
+	if l.exec != nil {
+		l.exec.SetAttributes(attribute.Int("exit_code", exitCode))
+		if exitCode != 0 {
+			l.exec.SetStatus(codes.Error, fmt.Sprintf("exit code %d", exitCode))
+		}
+		l.exec.End()
+		l.exec = nil
+	}
+	l.mu.Unlock()
+
+	l.Logger.Exited(exitCode)
+}
+
+func (l *Logger) startFile(kind string, file tug.File) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.endStaging()
+
+	_, span := l.tracer.Start(l.ctx, kind, trace.WithAttributes(
+		attribute.String("url", file.URL),
+		attribute.String("path", file.Path),
+	))
+	l.files[kind+" "+file.Path] = span
+}
+
+func (l *Logger) endFile(kind string, file tug.File) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := kind + " " + file.Path
+	span, ok := l.files[key]
+	if !ok {
+		return
+	}
+	span.SetAttributes(attribute.Int64("size", file.Size))
+	span.End()
+	delete(l.files, key)
+}
+
+// endStaging ends the staging span, if it's still open.
+// The caller must hold l.mu.
+func (l *Logger) endStaging() {
+	if l.staging != nil {
+		l.staging.End()
+		l.staging = nil
+	}
+}
@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer := provider.Tracer("tugboat")
+
+	log := NewLogger(context.Background(), tracer, "task-1", tug.EmptyLogger{})
+
+	in := tug.File{URL: "gs://bucket/in.txt", Path: "/stage/in.txt"}
+	out := tug.File{URL: "gs://bucket/out.txt", Path: "/stage/out.txt"}
+	failed := tug.File{URL: "gs://bucket/failed.txt", Path: "/stage/failed.txt"}
+
+	log.StartTime(time.Now())
+	log.DownloadStarted(in)
+	in.Size = 42
+	log.DownloadFinished(in)
+	log.Running()
+	log.Exited(3)
+	log.UploadStarted(out)
+	log.UploadFinished(out)
+	log.UploadStarted(failed)
+	log.EndTime(time.Now())
+
+	spans := exporter.GetSpans()
+	var names []string
+	byName := map[string]tracetest.SpanStub{}
+	for _, s := range spans {
+		names = append(names, s.Name)
+		if _, ok := byName[s.Name]; !ok {
+			byName[s.Name] = s
+		}
+	}
+
+	expected := []string{"staging", "download", "exec", "upload", "upload", "task"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("unexpected spans %v", names)
+	}
+
+	task := byName["task"]
+	for _, s := range spans {
+		if s.Name != "task" && s.Parent.SpanID() != task.SpanContext.SpanID() {
+			t.Errorf("span %s is not a child of the task span", s.Name)
+		}
+	}
+
+	download := byName["download"]
+	if !hasAttr(download.Attributes, attribute.String("url", "gs://bucket/in.txt")) {
+		t.Errorf("download span is missing the url attribute: %v", download.Attributes)
+	}
+	if !hasAttr(download.Attributes, attribute.Int64("size", 42)) {
+		t.Errorf("download span is missing the size attribute: %v", download.Attributes)
+	}
+
+	exec := byName["exec"]
+	if !hasAttr(exec.Attributes, attribute.Int("exit_code", 3)) {
+		t.Errorf("exec span is missing the exit code attribute: %v", exec.Attributes)
+	}
+	if exec.Status.Code != codes.Error {
+		t.Errorf("expected exec span to have an error status")
+	}
+
+	// The unfinished upload is ended at EndTime, with an error.
+	if spans[4].Status.Code != codes.Error {
+		t.Errorf("expected unfinished upload span to have an error status")
+	}
+}
+
+func hasAttr(attrs []attribute.KeyValue, kv attribute.KeyValue) bool {
+	for _, a := range attrs {
+		if a == kv {
+			return true
+		}
+	}
+	return false
+}
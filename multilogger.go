@@ -0,0 +1,255 @@
+package tugboat
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// multiLoggerBuffer is the number of events buffered for each logger
+// of a MultiLogger.
+const multiLoggerBuffer = 1000
+
+// multiLoggerTimeout is how long a MultiLogger waits for a logger
+// with a full buffer to accept an event, before dropping the event.
+const multiLoggerTimeout = 5 * time.Second
+
+// MultiLogger forwards every event to several loggers, e.g. to the console
+// and to a file.
+//
+// Each logger receives its events, in order, from its own goroutine,
+// so a slow or panicking logger can't break task execution. If a logger
+// falls behind by more than a fixed number of events, output written to
+// Stdout, Stderr and ServiceLog is dropped for that logger until it catches
+// up, and other events wait for it, up to a timeout. Once the logger
+// catches up, it's warned of the number of dropped events. A panic in
+// a logger is recovered, and reported to the other loggers, unless
+// the MultiLogger was closed.
+type MultiLogger struct {
+	sinks   []*logSink
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewMultiLogger returns a MultiLogger which forwards events to the given loggers.
+// Callers must call Close once the task is done, e.g. after Run returns, to
+// deliver the buffered events and stop the goroutine of each logger.
+func NewMultiLogger(loggers ...Logger) *MultiLogger {
+	m := &MultiLogger{timeout: multiLoggerTimeout}
+	for i, log := range loggers {
+		s := &logSink{index: i, log: log, events: make(chan func(Logger), multiLoggerBuffer)}
+		m.sinks = append(m.sinks, s)
+		m.wg.Add(1)
+		go s.run(m)
+	}
+	return m
+}
+
+// Close waits for the buffered events to be delivered.
+// Events sent after Close are dropped.
+func (m *MultiLogger) Close() error {
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		for _, s := range m.sinks {
+			close(s.events)
+		}
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+	return nil
+}
+
+// send queues the event for every logger, waiting for loggers
+// with a full buffer.
+func (m *MultiLogger) send(event func(Logger)) {
+	m.sendExcept(nil, event, m.timeout)
+}
+
+// sendExcept queues the event for every logger but skip,
+// waiting up to timeout for each logger with a full buffer.
+func (m *MultiLogger) sendExcept(skip *logSink, event func(Logger), timeout time.Duration) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return
+	}
+	for _, s := range m.sinks {
+		if s != skip {
+			s.wait(event, timeout)
+		}
+	}
+}
+
+func (m *MultiLogger) StartTime(t time.Time) {
+	m.send(func(l Logger) { l.StartTime(t) })
+}
+func (m *MultiLogger) EndTime(t time.Time) {
+	m.send(func(l Logger) { l.EndTime(t) })
+}
+func (m *MultiLogger) Meta(key string, value interface{}) {
+	m.send(func(l Logger) { l.Meta(key, value) })
+}
+func (m *MultiLogger) Version(v Version) {
+	m.send(func(l Logger) { l.Version(v) })
+}
+func (m *MultiLogger) Debug(msg string, fields ...interface{}) {
+	m.send(func(l Logger) { l.Debug(msg, fields...) })
+}
+func (m *MultiLogger) Info(msg string, fields ...interface{}) {
+	m.send(func(l Logger) { l.Info(msg, fields...) })
+}
+func (m *MultiLogger) Warn(msg string, fields ...interface{}) {
+	m.send(func(l Logger) { l.Warn(msg, fields...) })
+}
+func (m *MultiLogger) Error(msg string, fields ...interface{}) {
+	m.send(func(l Logger) { l.Error(msg, fields...) })
+}
+func (m *MultiLogger) DownloadStarted(file File) {
+	m.send(func(l Logger) { l.DownloadStarted(file) })
+}
+func (m *MultiLogger) DownloadFinished(file File) {
+	m.send(func(l Logger) { l.DownloadFinished(file) })
+}
+func (m *MultiLogger) UploadStarted(file File) {
+	m.send(func(l Logger) { l.UploadStarted(file) })
+}
+func (m *MultiLogger) UploadFinished(file File) {
+	m.send(func(l Logger) { l.UploadFinished(file) })
+}
+func (m *MultiLogger) Running() {
+	m.send(func(l Logger) { l.Running() })
+}
+func (m *MultiLogger) Exited(exitCode int) {
+	m.send(func(l Logger) { l.Exited(exitCode) })
+}
+func (m *MultiLogger) Finished(state State, err error) {
+	m.send(func(l Logger) { l.Finished(state, err) })
+}
+func (m *MultiLogger) Stdout() io.Writer {
+	return m.writer(Logger.Stdout)
+}
+func (m *MultiLogger) Stderr() io.Writer {
+	return m.writer(Logger.Stderr)
+}
+func (m *MultiLogger) ServiceLog(name string) io.Writer {
+	return m.writer(func(l Logger) io.Writer {
+		return l.ServiceLog(name)
+	})
+}
+
+// writer combines the writers of each logger, as returned by get.
+func (m *MultiLogger) writer(get func(Logger) io.Writer) io.Writer {
+	var writers []io.Writer
+	for _, s := range m.sinks {
+		writers = append(writers, &sinkWriter{m, s, get})
+	}
+	return io.MultiWriter(writers...)
+}
+
+type logSink struct {
+	index  int
+	log    Logger
+	events chan func(Logger)
+
+	// droppedOutput and droppedEvents count the writes and events
+	// dropped since the logger was last warned.
+	droppedOutput int64
+	droppedEvents int64
+}
+
+// wait queues an event, waiting up to timeout if the buffer is full.
+func (s *logSink) wait(event func(Logger), timeout time.Duration) {
+	select {
+	case s.events <- event:
+		return
+	default:
+	}
+	if timeout <= 0 {
+		atomic.AddInt64(&s.droppedEvents, 1)
+		return
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case s.events <- event:
+	case <-t.C:
+		atomic.AddInt64(&s.droppedEvents, 1)
+	}
+}
+
+func (s *logSink) run(m *MultiLogger) {
+	defer m.wg.Done()
+	for event := range s.events {
+		s.call(m, event)
+		s.warnDropped(m)
+	}
+}
+
+// warnDropped warns the logger of the events dropped since
+// the last warning, if any.
+func (s *logSink) warnDropped(m *MultiLogger) {
+	output := atomic.SwapInt64(&s.droppedOutput, 0)
+	events := atomic.SwapInt64(&s.droppedEvents, 0)
+	if output == 0 && events == 0 {
+		return
+	}
+	s.call(m, func(l Logger) {
+		l.Warn("logger fell behind, events were dropped",
+			"output", output, "events", events)
+	})
+}
+
+// call calls the event, recovering from a panic in the logger.
+// The panic is reported to the other loggers, without waiting
+// for them, since they may be waiting for this logger.
+func (s *logSink) call(m *MultiLogger, event func(Logger)) {
+	defer func() {
+		if r := recover(); r != nil {
+			m.sendExcept(s, func(l Logger) {
+				l.Error("logger panicked", "logger", s.index, "panic", fmt.Sprint(r))
+			}, 0)
+		}
+	}()
+	event(s.log)
+}
+
+// sinkWriter queues writes for a single logger's writer.
+// Writes never fail or block, so one logger can't stop
+// the output reaching the others. Writes to a logger with
+// a full buffer are dropped and counted.
+type sinkWriter struct {
+	m    *MultiLogger
+	sink *logSink
+	get  func(Logger) io.Writer
+}
+
+func (w *sinkWriter) Write(p []byte) (int, error) {
+	// The caller may reuse p after Write returns.
+	buf := make([]byte, len(p))
+	copy(buf, p)
+
+	w.m.mu.RLock()
+	defer w.m.mu.RUnlock()
+	if w.m.closed {
+		return len(p), nil
+	}
+
+	event := func(l Logger) {
+		if out := w.get(l); out != nil {
+			out.Write(buf)
+		}
+	}
+	select {
+	case w.sink.events <- event:
+	default:
+		atomic.AddInt64(&w.sink.droppedOutput, 1)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,180 @@
+package tugboat
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordLogger records the events it receives. If hold is set,
+// the first event waits until hold is closed, after closing entered.
+// If notify is set, it receives every recorded event.
+type recordLogger struct {
+	EmptyLogger
+	hold, entered chan struct{}
+	notify        chan string
+	panicOn       string
+
+	mu     sync.Mutex
+	events []string
+	held   bool
+}
+
+func (r *recordLogger) record(event string) {
+	if r.hold != nil && !r.held {
+		r.held = true
+		close(r.entered)
+		<-r.hold
+	}
+	if event == r.panicOn {
+		panic("oops")
+	}
+	r.mu.Lock()
+	r.events = append(r.events, event)
+	r.mu.Unlock()
+	if r.notify != nil {
+		r.notify <- event
+	}
+}
+
+func (r *recordLogger) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func (r *recordLogger) StartTime(t time.Time) { r.record("StartTime") }
+func (r *recordLogger) EndTime(t time.Time)   { r.record("EndTime") }
+func (r *recordLogger) Running()              { r.record("Running") }
+func (r *recordLogger) Exited(exitCode int)   { r.record(fmt.Sprint("Exited ", exitCode)) }
+func (r *recordLogger) Info(msg string, fields ...interface{}) {
+	r.record(fmt.Sprint("Info ", msg, fields))
+}
+func (r *recordLogger) Warn(msg string, fields ...interface{}) {
+	r.record(fmt.Sprint("Warn ", msg, fields))
+}
+func (r *recordLogger) Error(msg string, fields ...interface{}) {
+	r.record(fmt.Sprint("Error ", msg, fields))
+}
+func (r *recordLogger) Finished(state State, err error) {
+	r.record(fmt.Sprint("Finished ", state))
+}
+func (r *recordLogger) Stdout() io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		r.record("Stdout " + string(p))
+		return len(p), nil
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestMultiLogger(t *testing.T) {
+	a := &recordLogger{}
+	b := &recordLogger{}
+	m := NewMultiLogger(a, b)
+
+	m.StartTime(time.Now())
+	m.Info("hello", "n", 1)
+	stdout := m.Stdout()
+	fmt.Fprint(stdout, "one")
+	m.Running()
+	fmt.Fprint(stdout, "two")
+	m.Exited(0)
+	m.EndTime(time.Now())
+	m.Close()
+
+	expected := []string{
+		"StartTime",
+		"Info hello[n 1]",
+		"Stdout one",
+		"Running",
+		"Stdout two",
+		"Exited 0",
+		"EndTime",
+	}
+	for _, l := range []*recordLogger{a, b} {
+		if got := l.recorded(); !reflect.DeepEqual(got, expected) {
+			t.Errorf("unexpected events: %q", got)
+		}
+	}
+
+	// Events after Close are dropped.
+	m.Running()
+	if got := a.recorded(); len(got) != len(expected) {
+		t.Errorf("unexpected events after Close: %q", got[len(expected):])
+	}
+}
+
+func TestMultiLoggerFullBuffer(t *testing.T) {
+	slow := &recordLogger{hold: make(chan struct{}), entered: make(chan struct{})}
+	m := NewMultiLogger(slow)
+
+	m.Info("first")
+	<-slow.entered
+
+	// Fill the buffer; the remaining output is dropped.
+	stdout := m.Stdout()
+	for i := 0; i < multiLoggerBuffer+10; i++ {
+		fmt.Fprint(stdout, i)
+	}
+
+	// Other events wait for the logger.
+	exited := make(chan struct{})
+	go func() {
+		m.Exited(1)
+		close(exited)
+	}()
+	close(slow.hold)
+	<-exited
+	m.Close()
+
+	events := slow.recorded()
+	if len(events) != multiLoggerBuffer+3 {
+		t.Fatalf("unexpected number of events: %d", len(events))
+	}
+	expected := []string{
+		"Info first[]",
+		"Warn logger fell behind, events were dropped[output 10 events 0]",
+		"Stdout 0",
+	}
+	if !reflect.DeepEqual(events[:3], expected) {
+		t.Errorf("unexpected events: %q", events[:3])
+	}
+	last := fmt.Sprint("Stdout ", multiLoggerBuffer-1)
+	if events[len(events)-2] != last || events[len(events)-1] != "Exited 1" {
+		t.Errorf("unexpected last events: %q", events[len(events)-2:])
+	}
+}
+
+func TestMultiLoggerPanic(t *testing.T) {
+	bad := &recordLogger{panicOn: "Info boom[]", notify: make(chan string, 1)}
+	good := &recordLogger{}
+	m := NewMultiLogger(bad, good)
+
+	m.Info("boom")
+	m.Running()
+	// Wait for the panic to be reported before closing.
+	<-bad.notify
+	m.Close()
+
+	// The panicking logger still receives later events.
+	if got := bad.recorded(); !reflect.DeepEqual(got, []string{"Running"}) {
+		t.Errorf("unexpected events of the panicking logger: %q", got)
+	}
+
+	var reported bool
+	for _, e := range good.recorded() {
+		if strings.HasPrefix(e, "Error logger panicked[logger 0 panic oops]") {
+			reported = true
+		}
+	}
+	if !reported {
+		t.Errorf("expected the panic to be reported, got %q", good.recorded())
+	}
+}
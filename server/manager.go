@@ -0,0 +1,339 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+)
+
+// ErrFinished is returned when canceling a task which already finished.
+var ErrFinished = errors.New("task already finished")
+
+// Manager queues submitted tasks and runs them through tugboat.Run,
+// each with its own context, so they can be canceled one by one.
+// The state of each task is kept in the Store.
+type Manager struct {
+	Store   *Store
+	Stage   *tug.Stage
+	Storage tug.Storage
+	// OutputDir holds the stdout and stderr of each task,
+	// so they can be streamed while the task runs, and read after.
+	OutputDir string
+
+	// Log receives the manager's own events.
+	Log tug.Logger
+	// NewLogger returns the logger for a task.
+	NewLogger func(task *tug.Task) (tug.Logger, error)
+	// NewExecutor returns the executor for a task, logging to log.
+	NewExecutor func(log tug.Logger) (tug.Executor, error)
+
+	// Concurrency is the maximum number of tasks running at once.
+	// Defaults to one.
+	Concurrency int
+
+	mu      sync.Mutex
+	pending []string
+	cancels map[string]context.CancelFunc
+	wake    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Start recovers the tasks in the store and starts running queued tasks,
+// until ctx is canceled. Tasks which were queued before a restart are run;
+// tasks which were running are marked as failed, since their
+// progress is lost.
+func (m *Manager) Start(ctx context.Context) error {
+	m.cancels = map[string]context.CancelFunc{}
+	m.wake = make(chan struct{}, 1)
+
+	if err := tug.EnsureDir(m.OutputDir, 0755); err != nil {
+		return err
+	}
+
+	recs, err := m.Store.List()
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		switch rec.State {
+		case tug.Queued:
+			m.push(rec.ID)
+		case tug.Initializing, tug.Running:
+			err := m.finish(rec.ID, tug.SystemErrorState, nil, errors.New("interrupted by a server restart"))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	concurrency := m.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	for i := 0; i < concurrency; i++ {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.loop(ctx)
+		}()
+	}
+	return nil
+}
+
+// Wait waits for the running tasks to stop, after the context
+// passed to Start is canceled.
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
+// Submit stores a task in the Queued state, and queues it to run.
+// A task without an ID is given a random one. Extra is stored
+// in the record as is, and may be nil.
+func (m *Manager) Submit(task *tug.Task, extra json.RawMessage) (*Record, error) {
+	if task.ID == "" {
+		task.ID = "task-" + randID()
+	}
+	if err := tug.ValidateID(task.ID); err != nil {
+		return nil, err
+	}
+
+	rec := &Record{
+		ID:      task.ID,
+		State:   tug.Queued,
+		Task:    task,
+		Extra:   extra,
+		Created: time.Now(),
+	}
+	if err := m.Store.Create(rec); err != nil {
+		return nil, err
+	}
+	m.push(task.ID)
+	return rec, nil
+}
+
+// Cancel cancels a queued or running task.
+func (m *Manager) Cancel(id string) error {
+	err := m.Store.Update(id, func(rec *Record) error {
+		if rec.State.Terminal() {
+			return ErrFinished
+		}
+		// Running tasks are marked canceled when Run returns.
+		if rec.State == tug.Queued {
+			now := time.Now()
+			rec.State = tug.Canceled
+			rec.Ended = &now
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	cancel, ok := m.cancels[id]
+	m.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return nil
+}
+
+// OutputPath returns the path of a task's "stdout" or "stderr" file.
+func (m *Manager) OutputPath(id, stream string) string {
+	return filepath.Join(m.OutputDir, id, stream)
+}
+
+func (m *Manager) push(id string) {
+	m.mu.Lock()
+	m.pending = append(m.pending, id)
+	m.mu.Unlock()
+	m.notify()
+}
+
+func (m *Manager) pop() (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.pending) == 0 {
+		return "", false
+	}
+	id := m.pending[0]
+	m.pending = m.pending[1:]
+	if len(m.pending) > 0 {
+		// Wake another runner for the remaining tasks.
+		m.notify()
+	}
+	return id, true
+}
+
+func (m *Manager) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Manager) loop(ctx context.Context) {
+	for {
+		id, ok := m.pop()
+		if !ok {
+			select {
+			case <-m.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		m.run(ctx, id)
+	}
+}
+
+func (m *Manager) run(ctx context.Context, id string) {
+	taskCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Register the cancel func before claiming the task, so that
+	// Cancel either sees the task queued, or finds the func.
+	m.mu.Lock()
+	m.cancels[id] = cancel
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.cancels, id)
+		m.mu.Unlock()
+	}()
+
+	// Claim the task, unless it was canceled while queued.
+	var task *tug.Task
+	err := m.Store.Update(id, func(rec *Record) error {
+		if rec.State != tug.Queued {
+			return ErrFinished
+		}
+		now := time.Now()
+		rec.State = tug.Initializing
+		rec.Started = &now
+		task = rec.Task
+		return nil
+	})
+	if err == ErrFinished {
+		return
+	}
+	if err != nil {
+		m.Log.Error("failed to start task", "task", id, "error", err)
+		return
+	}
+
+	result, state, err := m.runTask(taskCtx, task)
+	if ferr := m.finish(id, state, result, err); ferr != nil {
+		m.Log.Error("failed to store task result", "task", id, "error", ferr)
+	}
+}
+
+// runTask runs a task, returning its result and final state.
+func (m *Manager) runTask(ctx context.Context, task *tug.Task) (*tug.TaskResult, tug.State, error) {
+	log, err := m.NewLogger(task)
+	if err != nil {
+		return nil, tug.FinalState(err), err
+	}
+	exec, err := m.NewExecutor(log)
+	if err != nil {
+		return nil, tug.FinalState(err), err
+	}
+
+	out, err := m.openOutput(task.ID)
+	if err != nil {
+		return nil, tug.FinalState(err), err
+	}
+	defer out.Close()
+	out.Logger = log
+	out.store = m.Store
+
+	// Store the running state when the first command starts.
+	// The final state is stored with the result.
+	sl := tug.NewStateLogger(out, func(from, to tug.State) {
+		if to != tug.Running {
+			return
+		}
+		err := m.Store.Update(task.ID, func(rec *Record) error {
+			rec.State = tug.Running
+			return nil
+		})
+		if err != nil {
+			m.Log.Error("failed to update task state", "task", task.ID, "error", err)
+		}
+	})
+	result, err := tug.Run(ctx, task, m.Stage, sl, m.Storage, exec)
+	return result, sl.State(), err
+}
+
+// finish stores the final state of a task.
+func (m *Manager) finish(id string, state tug.State, result *tug.TaskResult, err error) error {
+	return m.Store.Update(id, func(rec *Record) error {
+		now := time.Now()
+		rec.State = state
+		rec.Result = result
+		rec.Ended = &now
+		if err != nil {
+			rec.Error = err.Error()
+		}
+		return nil
+	})
+}
+
+func (m *Manager) openOutput(id string) (*outputLogger, error) {
+	if err := tug.EnsureDir(filepath.Join(m.OutputDir, id), 0755); err != nil {
+		return nil, err
+	}
+	stdout, err := os.Create(m.OutputPath(id, "stdout"))
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := os.Create(m.OutputPath(id, "stderr"))
+	if err != nil {
+		stdout.Close()
+		return nil, err
+	}
+	return &outputLogger{stdout: stdout, stderr: stderr, id: id}, nil
+}
+
+// outputLogger copies the task's stdout and stderr to files,
+// and records uploaded outputs in the store.
+type outputLogger struct {
+	tug.Logger
+	stdout, stderr *os.File
+	store          *Store
+	id             string
+}
+
+func (o *outputLogger) UploadFinished(file tug.File) {
+	err := o.store.Update(o.id, func(rec *Record) error {
+		rec.Outputs = append(rec.Outputs, file)
+		return nil
+	})
+	if err != nil {
+		o.Logger.Error("failed to record output", "url", file.URL, "error", err)
+	}
+	o.Logger.UploadFinished(file)
+}
+
+func (o *outputLogger) Stdout() io.Writer {
+	return io.MultiWriter(o.Logger.Stdout(), o.stdout)
+}
+
+func (o *outputLogger) Stderr() io.Writer {
+	return io.MultiWriter(o.Logger.Stderr(), o.stderr)
+}
+
+func (o *outputLogger) Close() error {
+	o.stdout.Close()
+	return o.stderr.Close()
+}
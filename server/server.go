@@ -0,0 +1,229 @@
+// Package server implements an HTTP API for submitting tasks,
+// monitoring their state, streaming their output and canceling them.
+//
+// The API is:
+//
+//	POST /v1/tasks               submit a task, as JSON or YAML (see package taskfile)
+//	GET  /v1/tasks               list tasks
+//	GET  /v1/tasks/{id}          get a task's state and result
+//	GET  /v1/tasks/{id}/stdout   get a task's stdout; with ?follow=true,
+//	GET  /v1/tasks/{id}/stderr   stream it until the task finishes
+//	POST /v1/tasks/{id}/cancel   cancel a task
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/buchanae/tugboat/taskfile"
+)
+
+// maxTaskSize limits the size of a submitted task.
+const maxTaskSize = 1 << 20
+
+// followInterval is how often a followed output file is checked for more data.
+const followInterval = 250 * time.Millisecond
+
+// NewHandler returns the HTTP handler of the API.
+func NewHandler(m *Manager) http.Handler {
+	s := &server{m: m}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/tasks", s.submit)
+	mux.HandleFunc("GET /v1/tasks", s.list)
+	mux.HandleFunc("GET /v1/tasks/{id}", s.get)
+	mux.HandleFunc("GET /v1/tasks/{id}/stdout", s.output("stdout"))
+	mux.HandleFunc("GET /v1/tasks/{id}/stderr", s.output("stderr"))
+	mux.HandleFunc("POST /v1/tasks/{id}/cancel", s.cancel)
+	return mux
+}
+
+type server struct {
+	m *Manager
+}
+
+// ErrorResponse is the body of an error response.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	// Details lists the problems with an invalid task.
+	Details taskfile.ErrorList `json:"details,omitempty"`
+}
+
+// summary is a task in the list response.
+type summary struct {
+	ID      string    `json:"id"`
+	State   string    `json:"state"`
+	Created time.Time `json:"created"`
+}
+
+func (s *server) submit(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxTaskSize))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	f, err := taskfile.Decode("request", body)
+	if err == nil && f.Task.ID == "" {
+		// Validate requires an ID, but the manager assigns one.
+		f.Task.ID = "task-" + randID()
+	}
+	if err == nil {
+		err = f.Validate()
+	}
+	if err != nil {
+		resp := ErrorResponse{Error: "invalid task"}
+		switch e := err.(type) {
+		case taskfile.ErrorList:
+			resp.Details = e
+		case *taskfile.Error:
+			resp.Details = taskfile.ErrorList{e}
+		default:
+			resp.Error = err.Error()
+		}
+		WriteJSON(w, http.StatusBadRequest, resp)
+		return
+	}
+
+	rec, err := s.m.Submit(f.Task, nil)
+	switch {
+	case err == ErrExists:
+		WriteError(w, http.StatusConflict, err)
+	case err != nil:
+		WriteError(w, http.StatusBadRequest, err)
+	default:
+		WriteJSON(w, http.StatusCreated, rec)
+	}
+}
+
+func (s *server) list(w http.ResponseWriter, r *http.Request) {
+	recs, err := s.m.Store.List()
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	tasks := []summary{}
+	for _, rec := range recs {
+		tasks = append(tasks, summary{ID: rec.ID, State: string(rec.State), Created: rec.Created})
+	}
+	WriteJSON(w, http.StatusOK, tasks)
+}
+
+func (s *server) get(w http.ResponseWriter, r *http.Request) {
+	rec, err := s.m.Store.Get(r.PathValue("id"))
+	if err != nil {
+		WriteStoreError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, rec)
+}
+
+func (s *server) cancel(w http.ResponseWriter, r *http.Request) {
+	err := s.m.Cancel(r.PathValue("id"))
+	if err == ErrFinished {
+		WriteError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		WriteStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// output serves a task's stdout or stderr. When following, the file is
+// streamed as it grows, until the task finishes or the client goes away.
+func (s *server) output(stream string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if _, err := s.m.Store.Get(id); err != nil {
+			WriteStoreError(w, err)
+			return
+		}
+		follow := r.URL.Query().Get("follow") == "true"
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		flusher, _ := w.(http.Flusher)
+
+		var f *os.File
+		defer func() {
+			if f != nil {
+				f.Close()
+			}
+		}()
+
+		for {
+			// The file is created when the task starts.
+			if f == nil {
+				var err error
+				f, err = os.Open(s.m.OutputPath(id, stream))
+				if err != nil && !os.IsNotExist(err) {
+					WriteError(w, http.StatusInternalServerError, err)
+					return
+				}
+			}
+
+			// Check whether the task is finished before reading,
+			// so no output written before it finished is missed.
+			rec, err := s.m.Store.Get(id)
+			if err != nil {
+				return
+			}
+			done := rec.State.Terminal()
+
+			if f != nil {
+				if _, err := io.Copy(w, f); err != nil {
+					return
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+
+			if !follow || done {
+				return
+			}
+			select {
+			case <-time.After(followInterval):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// WriteJSON writes v as the indented JSON body of a response.
+func WriteJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// WriteError writes an ErrorResponse with the message of err.
+func WriteError(w http.ResponseWriter, code int, err error) {
+	WriteJSON(w, code, ErrorResponse{Error: err.Error()})
+}
+
+// WriteStoreError writes an error returned by a Store,
+// which is a 404 if the task wasn't found.
+func WriteStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotFound) {
+		WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	WriteError(w, http.StatusInternalServerError, err)
+}
+
+// randID returns a random hex string, for task IDs.
+func randID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
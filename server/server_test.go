@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	"github.com/buchanae/tugboat/storage/local"
+)
+
+// fakeExec echoes the task's command to stdout,
+// and blocks tasks whose command is "block" until canceled.
+type fakeExec struct{}
+
+func (fakeExec) Exec(ctx context.Context, task *tug.StagedTask, stdio *tug.Stdio) error {
+	fmt.Fprint(stdio.Stdout, strings.Join(task.Command, " "))
+	if task.Command[0] == "block" {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func newServer(t *testing.T) (*httptest.Server, *Manager) {
+	dir := t.TempDir()
+	store, err := OpenStore(filepath.Join(dir, "tugboat.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	stage, err := tug.NewStage(filepath.Join(dir, "stage"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quiet := tug.EmptyLogger{Level: tug.ErrorLevel + 1}
+	m := &Manager{
+		Store:     store,
+		Stage:     stage,
+		Storage:   &local.Local{},
+		OutputDir: filepath.Join(dir, "outputs"),
+		Log:       quiet,
+		NewLogger: func(*tug.Task) (tug.Logger, error) {
+			return quiet, nil
+		},
+		NewExecutor: func(tug.Logger) (tug.Executor, error) {
+			return fakeExec{}, nil
+		},
+		Concurrency: 2,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewHandler(m))
+	t.Cleanup(func() {
+		srv.Close()
+		cancel()
+		m.Wait()
+	})
+	return srv, m
+}
+
+func post(t *testing.T, url, body string) *http.Response {
+	resp, err := http.Post(url, "application/yaml", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func getRecord(t *testing.T, srv *httptest.Server, id string) *Record {
+	resp, err := http.Get(srv.URL + "/v1/tasks/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status getting %s: %s", id, resp.Status)
+	}
+	rec := &Record{}
+	if err := json.NewDecoder(resp.Body).Decode(rec); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func waitState(t *testing.T, srv *httptest.Server, id string, state tug.State) *Record {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := getRecord(t, srv, id)
+		if rec.State == state {
+			return rec
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s to be %s, it is %s", id, state, rec.State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	srv, _ := newServer(t)
+
+	resp := post(t, srv.URL+"/v1/tasks", "{id: hello, containerImage: alpine, command: [echo, hello]}")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status: %s", resp.Status)
+	}
+
+	rec := waitState(t, srv, "hello", tug.Complete)
+	if rec.Result == nil || rec.Result.ExitCode != 0 {
+		t.Errorf("unexpected result: %+v", rec.Result)
+	}
+	if rec.Started == nil || rec.Ended == nil {
+		t.Errorf("expected start and end times: %+v", rec)
+	}
+
+	resp, err := http.Get(srv.URL + "/v1/tasks/hello/stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(out) != "echo hello" {
+		t.Errorf("unexpected stdout: %q", out)
+	}
+
+	// The ID is taken.
+	resp = post(t, srv.URL+"/v1/tasks", "{id: hello, containerImage: alpine, command: [echo]}")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("unexpected status for a duplicate ID: %s", resp.Status)
+	}
+}
+
+func TestSubmitInvalid(t *testing.T) {
+	srv, _ := newServer(t)
+
+	resp := post(t, srv.URL+"/v1/tasks", "{containerImage: alpine}")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %s", resp.Status)
+	}
+	var body ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Details) != 1 || body.Details[0].Field != "command" {
+		t.Errorf("unexpected details: %+v", body.Details)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	srv, _ := newServer(t)
+
+	resp := post(t, srv.URL+"/v1/tasks", "{id: block, containerImage: alpine, command: [block]}")
+	resp.Body.Close()
+	waitState(t, srv, "block", tug.Running)
+
+	// Follow stdout while the task runs.
+	follow := make(chan string)
+	go func() {
+		resp, err := http.Get(srv.URL + "/v1/tasks/block/stdout?follow=true")
+		if err != nil {
+			follow <- err.Error()
+			return
+		}
+		out, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		follow <- string(out)
+	}()
+
+	resp = post(t, srv.URL+"/v1/tasks/block/cancel", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected status: %s", resp.Status)
+	}
+	waitState(t, srv, "block", tug.Canceled)
+
+	select {
+	case out := <-follow:
+		if out != "block" {
+			t.Errorf("unexpected followed stdout: %q", out)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected following to stop when the task finished")
+	}
+
+	resp = post(t, srv.URL+"/v1/tasks/block/cancel", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("unexpected status canceling a finished task: %s", resp.Status)
+	}
+
+	resp = post(t, srv.URL+"/v1/tasks/missing/cancel", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected status canceling a missing task: %s", resp.Status)
+	}
+}
+
+func TestCancelInitializing(t *testing.T) {
+	srv, m := newServer(t)
+
+	// Hold the task in the Initializing state.
+	initializing := make(chan struct{})
+	release := make(chan struct{})
+	quiet := tug.EmptyLogger{Level: tug.ErrorLevel + 1}
+	m.NewLogger = func(*tug.Task) (tug.Logger, error) {
+		close(initializing)
+		<-release
+		return quiet, nil
+	}
+
+	resp := post(t, srv.URL+"/v1/tasks", "{id: init, containerImage: alpine, command: [block]}")
+	resp.Body.Close()
+	<-initializing
+	if rec := getRecord(t, srv, "init"); rec.State != tug.Initializing {
+		t.Fatalf("unexpected state: %s", rec.State)
+	}
+
+	resp = post(t, srv.URL+"/v1/tasks/init/cancel", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected status: %s", resp.Status)
+	}
+	close(release)
+	waitState(t, srv, "init", tug.Canceled)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// ErrNotFound is returned for a task ID which isn't in the store.
+	ErrNotFound = errors.New("task not found")
+	// ErrExists is returned when creating a task whose ID is in the store.
+	ErrExists = errors.New("task already exists")
+)
+
+var tasksBucket = []byte("tasks")
+
+// Record is the stored state of a task.
+type Record struct {
+	ID     string          `json:"id"`
+	State  tug.State       `json:"state"`
+	Task   *tug.Task       `json:"task"`
+	Result *tug.TaskResult `json:"result,omitempty"`
+	// Error is the error returned by Run, if any.
+	Error string `json:"error,omitempty"`
+	// Outputs lists the files uploaded so far.
+	Outputs []tug.File `json:"outputs,omitempty"`
+	// Extra holds data of the API the task was submitted through,
+	// e.g. the original TES task, which doesn't map onto tug.Task.
+	Extra json.RawMessage `json:"extra,omitempty"`
+
+	Created time.Time  `json:"created"`
+	Started *time.Time `json:"started,omitempty"`
+	Ended   *time.Time `json:"ended,omitempty"`
+}
+
+// Store keeps task records in an embedded database file,
+// so they survive restarts.
+type Store struct {
+	db *bolt.DB
+}
+
+// OpenStore opens the database at path, creating it if needed.
+func OpenStore(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(tasksBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Create stores a new record, returning ErrExists if its ID is taken.
+func (s *Store) Create(rec *Record) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tasksBucket)
+		if b.Get([]byte(rec.ID)) != nil {
+			return ErrExists
+		}
+		return put(b, rec)
+	})
+}
+
+// Get returns the record of a task, or ErrNotFound.
+func (s *Store) Get(id string) (*Record, error) {
+	var rec *Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		rec, err = get(tx.Bucket(tasksBucket), id)
+		return err
+	})
+	return rec, err
+}
+
+// Update calls fn to modify the record of a task, and stores the result,
+// in one transaction. If fn returns an error, the record isn't changed.
+func (s *Store) Update(id string, fn func(rec *Record) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tasksBucket)
+		rec, err := get(b, id)
+		if err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+		return put(b, rec)
+	})
+}
+
+// List returns all records, oldest first.
+func (s *Store) List() ([]*Record, error) {
+	var recs []*Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tasksBucket).ForEach(func(k, v []byte) error {
+			rec := &Record{}
+			if err := json.Unmarshal(v, rec); err != nil {
+				return err
+			}
+			recs = append(recs, rec)
+			return nil
+		})
+	})
+	sort.SliceStable(recs, func(i, j int) bool {
+		return recs[i].Created.Before(recs[j].Created)
+	})
+	return recs, err
+}
+
+func get(b *bolt.Bucket, id string) (*Record, error) {
+	data := b.Get([]byte(id))
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	rec := &Record{}
+	return rec, json.Unmarshal(data, rec)
+}
+
+func put(b *bolt.Bucket, rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(rec.ID), data)
+}
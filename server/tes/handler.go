@@ -0,0 +1,247 @@
+package tes
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	tug "github.com/buchanae/tugboat"
+	"github.com/buchanae/tugboat/server"
+)
+
+// Prefix is the path under which the API is served.
+const Prefix = "/ga4gh/tes/v1"
+
+const (
+	// maxTaskSize limits the size of a submitted task.
+	maxTaskSize = 1 << 20
+	// defaultPageSize and maxPageSize limit the number of tasks
+	// in a list response, as recommended by the TES schema.
+	defaultPageSize = 256
+	maxPageSize     = 2048
+)
+
+// NewHandler returns the HTTP handler of the TES API.
+func NewHandler(m *server.Manager) http.Handler {
+	h := &handler{m: m}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+Prefix+"/service-info", h.serviceInfo)
+	mux.HandleFunc("POST "+Prefix+"/tasks", h.create)
+	mux.HandleFunc("GET "+Prefix+"/tasks", h.list)
+	mux.HandleFunc("GET "+Prefix+"/tasks/{id}", h.get)
+	// The cancel path is "/tasks/{id}:cancel", which can't be
+	// matched by a pattern, since wildcards are whole segments.
+	mux.HandleFunc("POST "+Prefix+"/tasks/{idcancel}", h.cancel)
+	return mux
+}
+
+type handler struct {
+	m *server.Manager
+}
+
+// ServiceInfo describes the service, as in the GA4GH service-info schema.
+type ServiceInfo struct {
+	ID               string       `json:"id"`
+	Name             string       `json:"name"`
+	Type             ServiceType  `json:"type"`
+	Organization     Organization `json:"organization"`
+	DocumentationURL string       `json:"documentationUrl,omitempty"`
+	Version          string       `json:"version"`
+}
+
+type ServiceType struct {
+	Group    string `json:"group"`
+	Artifact string `json:"artifact"`
+	Version  string `json:"version"`
+}
+
+type Organization struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type createResponse struct {
+	ID string `json:"id"`
+}
+
+type listResponse struct {
+	Tasks         []*Task `json:"tasks"`
+	NextPageToken string  `json:"next_page_token,omitempty"`
+}
+
+func (h *handler) serviceInfo(w http.ResponseWriter, r *http.Request) {
+	v := tug.BuildVersion()
+	server.WriteJSON(w, http.StatusOK, ServiceInfo{
+		ID:   "io.github.buchanae.tugboat",
+		Name: v.Name,
+		Type: ServiceType{
+			Group:    "org.ga4gh",
+			Artifact: "tes",
+			Version:  "1.1.0",
+		},
+		Organization: Organization{
+			Name: "tugboat",
+			URL:  v.Doc,
+		},
+		DocumentationURL: v.Doc,
+		Version:          strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor) + "." + strconv.Itoa(v.Patch),
+	})
+}
+
+func (h *handler) create(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxTaskSize))
+	if err != nil {
+		server.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	t := &Task{}
+	if err := json.Unmarshal(body, t); err != nil {
+		server.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	task, err := ToTask(t)
+	if err != nil {
+		server.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Output-only fields aren't stored.
+	t.ID = ""
+	t.State = ""
+	t.Logs = nil
+	t.CreationTime = nil
+	extra, err := json.Marshal(t)
+	if err != nil {
+		server.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	rec, err := h.m.Submit(task, extra)
+	if err != nil {
+		server.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	server.WriteJSON(w, http.StatusOK, createResponse{ID: rec.ID})
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	view, err := parseView(q.Get("view"))
+	if err != nil {
+		server.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	size := defaultPageSize
+	if s := q.Get("page_size"); s != "" {
+		size, err = strconv.Atoi(s)
+		if err != nil || size < 1 {
+			server.WriteError(w, http.StatusBadRequest, errors.New("page_size must be a positive integer"))
+			return
+		}
+		if size > maxPageSize {
+			size = maxPageSize
+		}
+	}
+
+	// The page token is the index of the first task of the page.
+	// Tasks are listed oldest first, so new tasks don't shift pages.
+	start := 0
+	if s := q.Get("page_token"); s != "" {
+		start, err = strconv.Atoi(s)
+		if err != nil || start < 0 {
+			server.WriteError(w, http.StatusBadRequest, errors.New("invalid page_token"))
+			return
+		}
+	}
+
+	recs, err := h.m.Store.List()
+	if err != nil {
+		server.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	namePrefix := q.Get("name_prefix")
+	state := tug.State(q.Get("state"))
+
+	resp := listResponse{Tasks: []*Task{}}
+	for i := start; i < len(recs); i++ {
+		rec := recs[i]
+		if state != "" && rec.State != state {
+			continue
+		}
+		t, err := submitted(rec)
+		if err != nil {
+			server.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if namePrefix != "" && (t == nil || !strings.HasPrefix(t.Name, namePrefix)) {
+			continue
+		}
+		if len(resp.Tasks) == size {
+			resp.NextPageToken = strconv.Itoa(i)
+			break
+		}
+		resp.Tasks = append(resp.Tasks, record(rec, t, view))
+	}
+	server.WriteJSON(w, http.StatusOK, resp)
+}
+
+func (h *handler) get(w http.ResponseWriter, r *http.Request) {
+	view, err := parseView(r.URL.Query().Get("view"))
+	if err != nil {
+		server.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	rec, err := h.m.Store.Get(r.PathValue("id"))
+	if err != nil {
+		server.WriteStoreError(w, err)
+		return
+	}
+	t, err := submitted(rec)
+	if err != nil {
+		server.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	server.WriteJSON(w, http.StatusOK, record(rec, t, view))
+}
+
+func (h *handler) cancel(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(r.PathValue("idcancel"), ":cancel")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	err := h.m.Cancel(id)
+	// Canceling a finished task isn't an error in TES.
+	if err != nil && err != server.ErrFinished {
+		server.WriteStoreError(w, err)
+		return
+	}
+	server.WriteJSON(w, http.StatusOK, struct{}{})
+}
+
+// submitted returns the TES task stored in a record,
+// or nil if the task wasn't submitted through the TES API.
+func submitted(rec *server.Record) (*Task, error) {
+	if len(rec.Extra) == 0 {
+		return nil, nil
+	}
+	t := &Task{}
+	return t, json.Unmarshal(rec.Extra, t)
+}
+
+// parseView parses the "view" query parameter. TES defaults to MINIMAL.
+func parseView(s string) (View, error) {
+	switch v := View(s); v {
+	case "":
+		return Minimal, nil
+	case Minimal, Basic, Full:
+		return v, nil
+	}
+	return "", errors.New(`view must be "MINIMAL", "BASIC" or "FULL"`)
+}
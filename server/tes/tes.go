@@ -0,0 +1,372 @@
+// Package tes implements the GA4GH Task Execution Schema (TES) v1 API
+// on top of a server.Manager, so that workflow engines which speak TES
+// can submit tasks to tugboat unchanged.
+//
+// TES tasks are translated into tugboat tasks, with each TES executor
+// becoming one of tug.Task.Executors. Containers run with a read-only
+// root filesystem, so the directory of each output is added to the task's
+// volumes, unless it's already in one. The original TES task is kept
+// in the task's record, so its name, description and tags are served
+// back as submitted. A few TES features have no equivalent in tugboat,
+// and tasks which use them are rejected: inputs with inline content,
+// directory inputs, and outputs with a path_prefix (wildcards).
+// Preemptible, zones and backend parameters are ignored.
+//
+// See https://github.com/ga4gh/task-execution-schemas
+package tes
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strings"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	"github.com/buchanae/tugboat/server"
+	"github.com/buchanae/tugboat/taskfile"
+)
+
+// Task is a TES task.
+type Task struct {
+	ID          string            `json:"id,omitempty"`
+	State       tug.State         `json:"state,omitempty"`
+	Name        string            `json:"name,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Inputs      []Input           `json:"inputs,omitempty"`
+	Outputs     []Output          `json:"outputs,omitempty"`
+	Resources   *Resources        `json:"resources,omitempty"`
+	Executors   []Executor        `json:"executors,omitempty"`
+	Volumes     []string          `json:"volumes,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Logs        []TaskLog         `json:"logs,omitempty"`
+
+	CreationTime *time.Time `json:"creation_time,omitempty"`
+}
+
+// FileType is the type of a TES input or output: FILE or DIRECTORY.
+type FileType string
+
+const (
+	File      FileType = "FILE"
+	Directory FileType = "DIRECTORY"
+)
+
+type Input struct {
+	Name        string   `json:"name,omitempty"`
+	Description string   `json:"description,omitempty"`
+	URL         string   `json:"url,omitempty"`
+	Path        string   `json:"path"`
+	Type        FileType `json:"type,omitempty"`
+	Content     string   `json:"content,omitempty"`
+	Streamable  bool     `json:"streamable,omitempty"`
+}
+
+type Output struct {
+	Name        string   `json:"name,omitempty"`
+	Description string   `json:"description,omitempty"`
+	URL         string   `json:"url"`
+	Path        string   `json:"path"`
+	PathPrefix  string   `json:"path_prefix,omitempty"`
+	Type        FileType `json:"type,omitempty"`
+}
+
+type Resources struct {
+	CPUCores    int      `json:"cpu_cores,omitempty"`
+	Preemptible bool     `json:"preemptible,omitempty"`
+	RAMGB       float64  `json:"ram_gb,omitempty"`
+	DiskGB      float64  `json:"disk_gb,omitempty"`
+	Zones       []string `json:"zones,omitempty"`
+
+	BackendParameters       map[string]string `json:"backend_parameters,omitempty"`
+	BackendParametersStrict bool              `json:"backend_parameters_strict,omitempty"`
+}
+
+type Executor struct {
+	Image       string            `json:"image"`
+	Command     []string          `json:"command"`
+	Workdir     string            `json:"workdir,omitempty"`
+	Stdin       string            `json:"stdin,omitempty"`
+	Stdout      string            `json:"stdout,omitempty"`
+	Stderr      string            `json:"stderr,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	IgnoreError bool              `json:"ignore_error,omitempty"`
+}
+
+// TaskLog describes one attempt at running a task.
+// Tugboat makes one attempt per task.
+type TaskLog struct {
+	Logs       []ExecutorLog     `json:"logs"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	StartTime  *time.Time        `json:"start_time,omitempty"`
+	EndTime    *time.Time        `json:"end_time,omitempty"`
+	Outputs    []OutputFileLog   `json:"outputs"`
+	SystemLogs []string          `json:"system_logs,omitempty"`
+}
+
+type ExecutorLog struct {
+	Stdout   string `json:"stdout,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+	ExitCode int    `json:"exit_code"`
+}
+
+type OutputFileLog struct {
+	URL  string `json:"url"`
+	Path string `json:"path"`
+	// SizeBytes is a string, as in the TES schema,
+	// since JSON numbers may not hold an int64.
+	SizeBytes string `json:"size_bytes"`
+}
+
+// View selects how much of a task is returned.
+type View string
+
+const (
+	// Minimal returns only the ID and state.
+	Minimal View = "MINIMAL"
+	// Basic returns everything but the executors' stdout and stderr,
+	// input contents and system logs.
+	Basic View = "BASIC"
+	// Full returns everything.
+	Full View = "FULL"
+)
+
+// ToTask translates a TES task into a tugboat task, and validates it
+// as a task file would be. The TES task's ID is ignored: IDs are
+// assigned by the server.
+func ToTask(t *Task) (*tug.Task, error) {
+	if err := unsupported(t); err != nil {
+		return nil, err
+	}
+
+	task := &tug.Task{Volumes: t.Volumes}
+	if r := t.Resources; r != nil {
+		task.Resources = tug.Resources{
+			CPUCores: float64(r.CPUCores),
+			RAMGB:    r.RAMGB,
+			DiskGB:   r.DiskGB,
+		}
+	}
+	for _, in := range t.Inputs {
+		task.Inputs = append(task.Inputs, tug.File{URL: in.URL, Path: in.Path})
+	}
+	for _, out := range t.Outputs {
+		task.Outputs = append(task.Outputs, tug.File{URL: out.URL, Path: out.Path})
+		task.Volumes = addVolume(task.Volumes, outputDir(out))
+	}
+	for _, e := range t.Executors {
+		task.Executors = append(task.Executors, tug.TaskExecutor{
+			ContainerImage: e.Image,
+			Command:        e.Command,
+			Env:            e.Env,
+			Workdir:        e.Workdir,
+			Stdin:          e.Stdin,
+			Stdout:         e.Stdout,
+			Stderr:         e.Stderr,
+			IgnoreError:    e.IgnoreError,
+		})
+	}
+
+	// Validate requires an ID, but the manager assigns one.
+	check := *task
+	check.ID = "tes"
+	if err := (&taskfile.File{Name: "task", Task: &check}).Validate(); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// outputDir returns the directory the command writes an output to.
+// Containers run with a read-only root filesystem, so it has to be a volume.
+func outputDir(out Output) string {
+	if out.Type == Directory {
+		return out.Path
+	}
+	return path.Dir(out.Path)
+}
+
+// addVolume adds dir to vols, unless it's already in one of them.
+func addVolume(vols []string, dir string) []string {
+	for _, v := range vols {
+		if dir == v || strings.HasPrefix(dir, strings.TrimSuffix(v, "/")+"/") {
+			return vols
+		}
+	}
+	return append(vols, dir)
+}
+
+// unsupported rejects tasks without executors, and tasks which
+// use TES features that have no equivalent in tugboat.
+func unsupported(t *Task) error {
+	if len(t.Executors) == 0 {
+		return errors.New("executors: at least one executor is required")
+	}
+	for i, in := range t.Inputs {
+		switch {
+		case in.Content != "":
+			return fmt.Errorf("inputs[%d].content: inputs with inline content aren't supported", i)
+		case in.Type == Directory:
+			return fmt.Errorf("inputs[%d].type: directory inputs aren't supported", i)
+		}
+	}
+	for i, out := range t.Outputs {
+		switch {
+		case out.PathPrefix != "":
+			return fmt.Errorf("outputs[%d].path_prefix: wildcard outputs aren't supported", i)
+		case out.Path != "" && outputDir(out) == "/":
+			return fmt.Errorf("outputs[%d].path: outputs can't be written to the root directory", i)
+		}
+	}
+	return nil
+}
+
+// FromTask translates a tugboat task into a TES task. It's used for
+// tasks which weren't submitted through the TES API.
+func FromTask(task *tug.Task) *Task {
+	t := &Task{Volumes: task.Volumes}
+
+	if r := task.Resources; r != (tug.Resources{}) {
+		t.Resources = &Resources{
+			CPUCores: int(math.Ceil(r.CPUCores)),
+			RAMGB:    r.RAMGB,
+			DiskGB:   r.DiskGB,
+		}
+	}
+	for _, in := range task.Inputs {
+		t.Inputs = append(t.Inputs, Input{URL: in.URL, Path: in.Path, Type: File})
+	}
+	for _, out := range task.Outputs {
+		t.Outputs = append(t.Outputs, Output{URL: out.URL, Path: out.Path})
+	}
+
+	if len(task.Executors) == 0 {
+		t.Executors = []Executor{{
+			Image:   task.ContainerImage,
+			Command: task.Command,
+			Workdir: task.Workdir,
+			Stdin:   task.Stdin,
+			Stdout:  task.Stdout,
+			Stderr:  task.Stderr,
+			Env:     task.Env,
+		}}
+		return t
+	}
+	for _, e := range task.Executors {
+		workdir := e.Workdir
+		if workdir == "" {
+			workdir = task.Workdir
+		}
+		t.Executors = append(t.Executors, Executor{
+			Image:       e.ContainerImage,
+			Command:     e.Command,
+			Workdir:     workdir,
+			Stdin:       e.Stdin,
+			Stdout:      e.Stdout,
+			Stderr:      e.Stderr,
+			Env:         mergeEnv(task.Env, e.Env),
+			IgnoreError: e.IgnoreError,
+		})
+	}
+	return t
+}
+
+func mergeEnv(task, exec map[string]string) map[string]string {
+	if len(task) == 0 {
+		return exec
+	}
+	env := map[string]string{}
+	for k, v := range task {
+		env[k] = v
+	}
+	for k, v := range exec {
+		env[k] = v
+	}
+	return env
+}
+
+// record returns the TES view of a task record.
+// t is the submitted TES task, or nil if the task
+// wasn't submitted through the TES API.
+func record(rec *server.Record, t *Task, view View) *Task {
+	if view == Minimal {
+		return &Task{ID: rec.ID, State: rec.State}
+	}
+
+	if t == nil {
+		t = FromTask(rec.Task)
+	}
+	t.ID = rec.ID
+	t.State = rec.State
+	created := rec.Created
+	t.CreationTime = &created
+	if rec.Started != nil {
+		t.Logs = []TaskLog{taskLog(rec, t)}
+	}
+
+	if view == Basic {
+		for i := range t.Inputs {
+			t.Inputs[i].Content = ""
+		}
+		for i := range t.Logs {
+			t.Logs[i].SystemLogs = nil
+			for j := range t.Logs[i].Logs {
+				t.Logs[i].Logs[j].Stdout = ""
+				t.Logs[i].Logs[j].Stderr = ""
+			}
+		}
+	}
+	return t
+}
+
+func taskLog(rec *server.Record, t *Task) TaskLog {
+	l := TaskLog{
+		Logs:      []ExecutorLog{},
+		Outputs:   []OutputFileLog{},
+		StartTime: rec.Started,
+		EndTime:   rec.Ended,
+	}
+
+	if r := rec.Result; r != nil {
+		results := r.Executors
+		if len(results) == 0 && rec.State.Terminal() {
+			results = []*tug.TaskResult{r}
+		}
+		for _, er := range results {
+			l.Logs = append(l.Logs, ExecutorLog{
+				Stdout:   er.Stdout,
+				Stderr:   er.Stderr,
+				ExitCode: er.ExitCode,
+			})
+		}
+	}
+
+	for _, f := range rec.Outputs {
+		l.Outputs = append(l.Outputs, OutputFileLog{
+			URL:       f.URL,
+			Path:      containerPath(t.Outputs, f.URL),
+			SizeBytes: fmt.Sprint(f.Size),
+		})
+	}
+
+	if rec.Error != "" {
+		l.SystemLogs = []string{rec.Error}
+	}
+	return l
+}
+
+// containerPath returns the container path of an uploaded file,
+// by matching its URL against the task's outputs. A file inside
+// a directory output has a URL inside the output's URL.
+func containerPath(outputs []Output, url string) string {
+	for _, out := range outputs {
+		if url == out.URL {
+			return out.Path
+		}
+		dir := strings.TrimSuffix(out.URL, "/") + "/"
+		if strings.HasPrefix(url, dir) {
+			return path.Join(out.Path, strings.TrimPrefix(url, dir))
+		}
+	}
+	return ""
+}
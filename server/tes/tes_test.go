@@ -0,0 +1,297 @@
+package tes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	"github.com/buchanae/tugboat/server"
+	"github.com/buchanae/tugboat/storage/local"
+)
+
+// fakeExec echoes the command to stdout, and fails commands
+// whose first argument is "fail".
+type fakeExec struct{}
+
+func (fakeExec) Exec(ctx context.Context, task *tug.StagedTask, stdio *tug.Stdio) error {
+	fmt.Fprint(stdio.Stdout, strings.Join(task.Command, " "))
+	if task.Command[0] == "fail" {
+		return &tug.ExecError{ExitCode: 3}
+	}
+	return nil
+}
+
+func newServer(t *testing.T) *httptest.Server {
+	dir := t.TempDir()
+	store, err := server.OpenStore(filepath.Join(dir, "tugboat.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	stage, err := tug.NewStage(filepath.Join(dir, "stage"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quiet := tug.EmptyLogger{Level: tug.ErrorLevel + 1}
+	m := &server.Manager{
+		Store:     store,
+		Stage:     stage,
+		Storage:   &local.Local{},
+		OutputDir: filepath.Join(dir, "outputs"),
+		Log:       quiet,
+		NewLogger: func(*tug.Task) (tug.Logger, error) {
+			return quiet, nil
+		},
+		NewExecutor: func(tug.Logger) (tug.Executor, error) {
+			return fakeExec{}, nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewHandler(m))
+	t.Cleanup(func() {
+		srv.Close()
+		cancel()
+		m.Wait()
+	})
+	return srv
+}
+
+func do(t *testing.T, method, url, body string, expectCode int, resp interface{}) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != expectCode {
+		t.Fatalf("unexpected status for %s %s: %s", method, url, r.Status)
+	}
+	if resp != nil {
+		if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func waitDone(t *testing.T, srv *httptest.Server, id string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		task := &Task{}
+		do(t, "GET", srv.URL+Prefix+"/tasks/"+id, "", http.StatusOK, task)
+		if task.State.Terminal() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s, it is %s", id, task.State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+const testTask = `{
+  "name": "hello",
+  "tags": {"workflow": "wf1"},
+  "executors": [
+    {"image": "alpine", "command": ["echo", "one"]},
+    {"image": "alpine", "command": ["fail", "two"], "ignore_error": true},
+    {"image": "alpine", "command": ["echo", "three"]}
+  ]
+}`
+
+func TestTask(t *testing.T) {
+	srv := newServer(t)
+
+	created := createResponse{}
+	do(t, "POST", srv.URL+Prefix+"/tasks", testTask, http.StatusOK, &created)
+	if created.ID == "" {
+		t.Fatal("expected an ID")
+	}
+	waitDone(t, srv, created.ID)
+
+	minimal := map[string]interface{}{}
+	do(t, "GET", srv.URL+Prefix+"/tasks/"+created.ID+"?view=MINIMAL", "", http.StatusOK, &minimal)
+	expectedMinimal := map[string]interface{}{"id": created.ID, "state": "COMPLETE"}
+	if !reflect.DeepEqual(minimal, expectedMinimal) {
+		t.Errorf("unexpected minimal view: %v", minimal)
+	}
+
+	full := &Task{}
+	do(t, "GET", srv.URL+Prefix+"/tasks/"+created.ID+"?view=FULL", "", http.StatusOK, full)
+	if full.Name != "hello" || full.Tags["workflow"] != "wf1" || len(full.Executors) != 3 {
+		t.Errorf("expected the submitted task, got %+v", full)
+	}
+	if len(full.Logs) != 1 {
+		t.Fatalf("expected one task log, got %+v", full.Logs)
+	}
+	var stdout []string
+	var codes []int
+	for _, l := range full.Logs[0].Logs {
+		stdout = append(stdout, l.Stdout)
+		codes = append(codes, l.ExitCode)
+	}
+	if !reflect.DeepEqual(stdout, []string{"echo one", "fail two", "echo three"}) {
+		t.Errorf("unexpected executor stdout: %q", stdout)
+	}
+	if !reflect.DeepEqual(codes, []int{0, 3, 0}) {
+		t.Errorf("unexpected executor exit codes: %v", codes)
+	}
+
+	basic := &Task{}
+	do(t, "GET", srv.URL+Prefix+"/tasks/"+created.ID+"?view=BASIC", "", http.StatusOK, basic)
+	if len(basic.Logs) != 1 || len(basic.Logs[0].Logs) != 3 || basic.Logs[0].Logs[0].Stdout != "" {
+		t.Errorf("expected executor logs without stdout, got %+v", basic.Logs)
+	}
+
+	// Canceling a finished task succeeds.
+	do(t, "POST", srv.URL+Prefix+"/tasks/"+created.ID+":cancel", "", http.StatusOK, nil)
+	do(t, "POST", srv.URL+Prefix+"/tasks/missing:cancel", "", http.StatusNotFound, nil)
+}
+
+func TestToTaskVolumes(t *testing.T) {
+	tests := []struct {
+		name     string
+		volumes  []string
+		outputs  []Output
+		expected []string
+	}{
+		{
+			name:     "no outputs",
+			expected: nil,
+		},
+		{
+			name: "outputs without volumes",
+			outputs: []Output{
+				{URL: "/data/out.bam", Path: "/outputs/out.bam"},
+				{URL: "/data/out.bai", Path: "/outputs/out.bai"},
+				{URL: "/data/logs", Path: "/work/logs", Type: Directory},
+			},
+			expected: []string{"/outputs", "/work/logs"},
+		},
+		{
+			name:     "output in a volume",
+			volumes:  []string{"/work"},
+			outputs:  []Output{{URL: "/data/out.bam", Path: "/work/align/out.bam"}},
+			expected: []string{"/work"},
+		},
+	}
+	for _, test := range tests {
+		task, err := ToTask(&Task{
+			Executors: []Executor{{Image: "alpine", Command: []string{"ls"}}},
+			Volumes:   test.volumes,
+			Outputs:   test.outputs,
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(task.Volumes, test.expected) {
+			t.Errorf("%s: unexpected volumes: %q", test.name, task.Volumes)
+		}
+	}
+}
+
+func TestCreateInvalid(t *testing.T) {
+	srv := newServer(t)
+
+	tests := map[string]string{
+		"no executors": `{"executors": []}`,
+		"content":      `{"executors": [{"image": "alpine", "command": ["cat"]}], "inputs": [{"path": "/in", "content": "hi"}]}`,
+		"directory":    `{"executors": [{"image": "alpine", "command": ["ls"]}], "inputs": [{"url": "/d", "path": "/d", "type": "DIRECTORY"}]}`,
+		"bad json":     `{`,
+		"no image":     `{"executors": [{"command": ["ls"]}]}`,
+		"no path":      `{"executors": [{"image": "alpine", "command": ["ls"]}], "outputs": [{"url": "/o"}]}`,
+		"root output":  `{"executors": [{"image": "alpine", "command": ["ls"]}], "outputs": [{"url": "/o", "path": "/out"}]}`,
+		"negative ram": `{"executors": [{"image": "alpine", "command": ["ls"]}], "resources": {"ram_gb": -1}}`,
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			do(t, "POST", srv.URL+Prefix+"/tasks", body, http.StatusBadRequest, nil)
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	srv := newServer(t)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		created := createResponse{}
+		do(t, "POST", srv.URL+Prefix+"/tasks", testTask, http.StatusOK, &created)
+		ids = append(ids, created.ID)
+	}
+
+	var listed []string
+	token := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("too many pages")
+		}
+		resp := listResponse{}
+		do(t, "GET", srv.URL+Prefix+"/tasks?page_size=2&page_token="+token, "", http.StatusOK, &resp)
+		for _, task := range resp.Tasks {
+			listed = append(listed, task.ID)
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		token = resp.NextPageToken
+	}
+	if !reflect.DeepEqual(listed, ids) {
+		t.Errorf("unexpected tasks: %v", listed)
+	}
+
+	resp := listResponse{}
+	do(t, "GET", srv.URL+Prefix+"/tasks?name_prefix=other", "", http.StatusOK, &resp)
+	if len(resp.Tasks) != 0 {
+		t.Errorf("expected no tasks named other*, got %+v", resp.Tasks)
+	}
+}
+
+func TestFromTask(t *testing.T) {
+	task := &tug.Task{
+		ContainerImage: "alpine",
+		Command:        []string{"md5sum", "/in"},
+		Inputs:         []tug.File{{URL: "/data/in", Path: "/in"}},
+		Resources:      tug.Resources{CPUCores: 1.5},
+	}
+	expected := &Task{
+		Inputs:    []Input{{URL: "/data/in", Path: "/in", Type: File}},
+		Resources: &Resources{CPUCores: 2},
+		Executors: []Executor{{Image: "alpine", Command: []string{"md5sum", "/in"}}},
+	}
+	if got := FromTask(task); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected task: %+v", got)
+	}
+}
+
+func TestContainerPath(t *testing.T) {
+	outputs := []Output{
+		{URL: "gs://bucket/out.txt", Path: "/out/out.txt"},
+		{URL: "gs://bucket/dir/", Path: "/out/dir"},
+	}
+	tests := map[string]string{
+		"gs://bucket/out.txt":     "/out/out.txt",
+		"gs://bucket/dir/a/b.txt": "/out/dir/a/b.txt",
+		"gs://other/x":            "",
+	}
+	for url, expected := range tests {
+		if got := containerPath(outputs, url); got != expected {
+			t.Errorf("unexpected path for %s: %q", url, got)
+		}
+	}
+}
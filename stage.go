@@ -3,7 +3,10 @@ package tugboat
 import (
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
 )
 
 type StagedTask struct {
@@ -12,20 +15,57 @@ type StagedTask struct {
 	Inputs, Outputs       []File
 	Volumes               []string
 	Stdin, Stdout, Stderr string
+	// Network is the container's network mode. It starts as Task.Network,
+	// and may be changed by ServiceExecutor.StartServices.
+	Network string
+
+	// Steps holds a staged task for each of Task.Executors, in order.
+	// Steps share the stage, inputs, outputs and volumes of their parent.
+	Steps []*StagedTask
+
+	// Result collects the outcome of the task while it runs.
+	// It's set by Run, and may be nil otherwise.
+	Result *TaskResult
+}
+
+var idRx = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidateID returns an error if id isn't a valid task ID. IDs name the
+// task's stage directory, so they must start with a letter or digit, and
+// contain only letters, digits, ".", "_" and "-".
+func ValidateID(id string) error {
+	if !idRx.MatchString(id) {
+		return errf(`invalid task ID %q: task IDs must start with a letter or digit, and may only contain letters, digits, ".", "_" and "-"`, id)
+	}
+	return nil
 }
 
 func StageTask(parent *Stage, task *Task) (*StagedTask, error) {
 
-	// Create task-specific stage
-	st, err := NewStage(filepath.Join(parent.Dir, task.ID), parent.Mode)
-	st.LeaveDir = parent.LeaveDir
-	if err != nil {
-		return nil, err
+	// Create task-specific stage. The directory must be directly
+	// under the parent, since it's removed when the task is done.
+	dir := filepath.Join(parent.Dir, task.ID)
+	if filepath.Dir(dir) != filepath.Clean(parent.Dir) {
+		return nil, errf("invalid task ID %q: the stage directory would be outside %s", task.ID, parent.Dir)
 	}
+	var st *Stage
+	var err error
+	if parent.DryRun {
+		st = &Stage{Dir: dir, Mode: parent.Mode}
+	} else {
+		st, err = NewStage(dir, parent.Mode)
+		if err != nil {
+			return nil, err
+		}
+	}
+	st.LeaveDir = parent.LeaveDir
+	st.DryRun = parent.DryRun
+	st.Checkpoint = parent.Checkpoint
 
 	stage := &StagedTask{
-		Stage: st,
-		Task:  task,
+		Stage:   st,
+		Task:    task,
+		Network: task.Network,
 	}
 
 	stdin, err := stage.EnsureMap(task.Stdin)
@@ -65,16 +105,140 @@ func StageTask(parent *Stage, task *Task) (*StagedTask, error) {
 		if err != nil {
 			return nil, wrap(err, "failed to map task volumes to stage: %s", path)
 		}
+		// Create the volume directory here, otherwise the container runtime
+		// may create it with its own (root) ownership.
+		if !st.DryRun {
+			err = EnsureDir(path, st.Mode)
+			if err != nil {
+				return nil, wrap(err, "failed to create task volume directory: %s", path)
+			}
+		}
 		stage.Volumes = append(stage.Volumes, path)
 	}
 
+	for i, e := range task.Executors {
+		step, err := stage.stageStep(e)
+		if err != nil {
+			return nil, wrap(err, "failed to stage executor %d", i)
+		}
+		stage.Steps = append(stage.Steps, step)
+	}
+
+	// The stage is given to the user by Run, after the inputs are downloaded.
+	if task.User != "" {
+		if _, _, err := ParseUser(task.User); err != nil {
+			return nil, err
+		}
+	}
+
 	return stage, nil
 }
 
+// stageStep stages one executor of a multi-command task.
+// The step's task is a copy of the parent task, with the command
+// and stdio of the executor.
+func (t *StagedTask) stageStep(e TaskExecutor) (*StagedTask, error) {
+	task := *t.Task
+	task.Executors = nil
+	task.ContainerImage = e.ContainerImage
+	task.Command = e.Command
+	task.Stdin = e.Stdin
+	task.Stdout = e.Stdout
+	task.Stderr = e.Stderr
+
+	if e.Workdir != "" {
+		task.Workdir = e.Workdir
+	}
+
+	task.Env = map[string]string{}
+	for k, v := range t.Task.Env {
+		task.Env[k] = v
+	}
+	for k, v := range e.Env {
+		task.Env[k] = v
+	}
+
+	step := &StagedTask{
+		Stage:   t.Stage,
+		Task:    &task,
+		Inputs:  t.Inputs,
+		Outputs: t.Outputs,
+		Volumes: t.Volumes,
+		Network: t.Network,
+	}
+
+	var err error
+	step.Stdin, err = step.EnsureMap(e.Stdin)
+	if err != nil {
+		return nil, wrap(err, "failed to map stdin")
+	}
+	step.Stdout, err = step.EnsureMap(e.Stdout)
+	if err != nil {
+		return nil, wrap(err, "failed to map stdout")
+	}
+	step.Stderr, err = step.EnsureMap(e.Stderr)
+	if err != nil {
+		return nil, wrap(err, "failed to map stderr")
+	}
+	return step, nil
+}
+
+// ParseUser parses a "uid:gid" string.
+func ParseUser(user string) (uid, gid int, err error) {
+	parts := strings.Split(user, ":")
+	if len(parts) != 2 {
+		return 0, 0, errf(`invalid user %q: expected "uid:gid"`, user)
+	}
+	uid, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, errf("invalid user %q: uid must be numeric", user)
+	}
+	gid, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, errf("invalid user %q: gid must be numeric", user)
+	}
+	return uid, gid, nil
+}
+
+// chownUser gives the stage, including downloaded inputs, to the task's
+// user, and returns true if ownership changed. Only root may give files
+// away, so when the worker isn't root, the stage is left as it is,
+// with a warning.
+func (t *StagedTask) chownUser(log Logger) (bool, error) {
+	if t.User == "" || t.DryRun {
+		return false, nil
+	}
+	uid, gid, err := ParseUser(t.User)
+	if err != nil {
+		return false, err
+	}
+	if uid == os.Getuid() && gid == os.Getgid() {
+		return false, nil
+	}
+	if os.Geteuid() != 0 {
+		log.Warn("not running as root, leaving the stage owned by the worker", "task", t.ID, "user", t.User)
+		return false, nil
+	}
+	if err := t.Chown(uid, gid); err != nil {
+		return false, wrap(err, "failed to change stage ownership to user %s", t.User)
+	}
+	return true, nil
+}
+
 type Stage struct {
 	Dir      string
 	Mode     os.FileMode
 	LeaveDir bool
+	// DryRun plans tasks without side effects: paths are mapped
+	// but not created, nothing is transferred, and executors
+	// describe their commands instead of running them.
+	DryRun bool
+	// Checkpoint records each finished download in the task's stage
+	// directory, with its size and checksum. When the task is run again
+	// with the same ID, inputs which are already downloaded and unchanged
+	// are skipped. Checkpoints survive a crash; to keep them after a
+	// failed run, set LeaveDir too.
+	Checkpoint bool
 }
 
 func NewStage(dir string, mode os.FileMode) (*Stage, error) {
@@ -92,14 +256,15 @@ func NewStage(dir string, mode os.FileMode) (*Stage, error) {
 }
 
 // EnsureMap calls stage.Map then EnsurePath.
+// In a dry run, the path is only mapped.
 func (s *Stage) EnsureMap(path string) (string, error) {
 	if path == "" {
 		return "", nil
 	}
 
 	mapped, err := s.Map(path)
-	if err != nil {
-		return "", err
+	if err != nil || s.DryRun {
+		return mapped, err
 	}
 	return mapped, EnsurePath(mapped, s.Mode)
 }
@@ -135,6 +300,25 @@ func (stage *Stage) Unmap(src string) string {
 	return p
 }
 
+// Chown recursively changes the owner of the stage directory and its contents.
+//
+// Regular files with more than one link are skipped: they share their inode
+// with a file outside the stage, e.g. an input which storage/local hard-linked
+// from its source, and changing their owner would change the owner of the
+// source too. Such files keep their owner, and stay readable by the task's
+// user as long as their mode allows it.
+func (stage *Stage) Chown(uid, gid int) error {
+	return filepath.Walk(stage.Dir, func(p string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if st, ok := f.Sys().(*syscall.Stat_t); ok && f.Mode().IsRegular() && st.Nlink > 1 {
+			return nil
+		}
+		return os.Lchown(p, uid, gid)
+	})
+}
+
 // RemoveAll removes the stage directory.
 func (stage *Stage) RemoveAll() error {
 	if stage.LeaveDir {
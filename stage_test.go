@@ -0,0 +1,152 @@
+package tugboat
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func TestParseUser(t *testing.T) {
+	tests := []struct {
+		user     string
+		uid, gid int
+		ok       bool
+	}{
+		{"1000:1000", 1000, 1000, true},
+		{"0:0", 0, 0, true},
+		{"1000:50", 1000, 50, true},
+		{"1000", 0, 0, false},
+		{"alice:staff", 0, 0, false},
+		{"1000:staff", 0, 0, false},
+		{"1000:1000:1000", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, test := range tests {
+		uid, gid, err := ParseUser(test.user)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: unexpected error: %v", test.user, err)
+			continue
+		}
+		if uid != test.uid || gid != test.gid {
+			t.Errorf("%q: unexpected uid:gid %d:%d", test.user, uid, gid)
+		}
+	}
+}
+
+func TestStageTaskID(t *testing.T) {
+	parent, err := NewStage(filepath.Join(t.TempDir(), "stage"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"", ".", "..", "../x", "a/../../etc", "a/b"} {
+		if _, err := StageTask(parent, &Task{ID: id}); err == nil {
+			t.Errorf("%q: expected an error", id)
+		}
+	}
+	entries, err := os.ReadDir(filepath.Dir(parent.Dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected nothing to be created outside the stage, got %d entries", len(entries))
+	}
+
+	staged, err := StageTask(parent, &Task{ID: "ok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staged.Dir != filepath.Join(parent.Dir, "ok") {
+		t.Errorf("unexpected stage directory: %s", staged.Dir)
+	}
+}
+
+func owner(t *testing.T, path string) int {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(info.Sys().(*syscall.Stat_t).Uid)
+}
+
+// ownerExec records the owner of the staged input, and writes
+// the output as the task's user, like a container would.
+type ownerExec struct {
+	t          *testing.T
+	inputOwner int
+}
+
+func (o *ownerExec) Exec(ctx context.Context, task *StagedTask, stdio *Stdio) error {
+	o.inputOwner = owner(o.t, task.Inputs[0].Path)
+	out := task.Outputs[0].Path
+	if err := os.WriteFile(out, []byte("out"), 0644); err != nil {
+		return err
+	}
+	uid, gid, _ := ParseUser(task.User)
+	return os.Chown(out, uid, gid)
+}
+
+// ownerStorage copies local files, and records the owner
+// of each uploaded file.
+type ownerStorage struct {
+	t      *testing.T
+	mu     sync.Mutex
+	owners map[string]int
+}
+
+func (s *ownerStorage) Get(ctx context.Context, url, abs string) error {
+	data, err := os.ReadFile(url)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(abs, data, 0644)
+}
+
+func (s *ownerStorage) Put(ctx context.Context, url, rel, abs string) error {
+	s.mu.Lock()
+	s.owners[url] = owner(s.t, abs)
+	s.mu.Unlock()
+	return nil
+}
+func (s *ownerStorage) SupportsGet(url string) bool { return true }
+func (s *ownerStorage) SupportsPut(url string) bool { return true }
+
+func TestUserOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing file ownership needs root")
+	}
+
+	in := filepath.Join(t.TempDir(), "in.txt")
+	if err := os.WriteFile(in, []byte("in"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stage, err := NewStage(t.TempDir(), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := &Task{
+		ID:      "owner",
+		User:    "1234:1234",
+		Inputs:  []File{{URL: in, Path: "/inputs/in.txt"}},
+		Volumes: []string{"/outputs"},
+		Outputs: []File{{URL: "/data/out.txt", Path: "/outputs/out.txt"}},
+	}
+	exec := &ownerExec{t: t}
+	store := &ownerStorage{t: t, owners: map[string]int{}}
+	log := EmptyLogger{Level: ErrorLevel + 1}
+	if _, err := Run(context.Background(), task, stage, log, store, exec); err != nil {
+		t.Fatal(err)
+	}
+
+	// Inputs are downloaded before the stage is given to the user.
+	if exec.inputOwner != 1234 {
+		t.Errorf("expected the input to be owned by the task's user, got %d", exec.inputOwner)
+	}
+	// Outputs are reclaimed before they're uploaded.
+	if got, ok := store.owners["/data/out.txt"]; !ok || got != os.Getuid() {
+		t.Errorf("expected the output to be owned by the worker, got %d", got)
+	}
+}
@@ -0,0 +1,157 @@
+package tugboat
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of a task.
+type State string
+
+const (
+	// Queued tasks are waiting to be claimed by a worker.
+	Queued State = "QUEUED"
+	// Initializing tasks are being staged, and their inputs downloaded.
+	Initializing State = "INITIALIZING"
+	// Running tasks are running their commands, or uploading outputs.
+	Running State = "RUNNING"
+	// Complete tasks finished successfully.
+	Complete State = "COMPLETE"
+	// ExecutorError tasks ran, but a command failed or timed out.
+	ExecutorError State = "EXECUTOR_ERROR"
+	// SystemErrorState tasks failed for reasons other than their
+	// commands, e.g. a failed download or a missing container runtime.
+	SystemErrorState State = "SYSTEM_ERROR"
+	// Canceled tasks were canceled before they finished.
+	Canceled State = "CANCELED"
+)
+
+// Terminal returns true if the state is final.
+func (s State) Terminal() bool {
+	switch s {
+	case Complete, ExecutorError, SystemErrorState, Canceled:
+		return true
+	}
+	return false
+}
+
+// transitions lists the states each state may move to.
+// Terminal states have none.
+var transitions = map[State][]State{
+	Queued:       {Initializing, SystemErrorState, Canceled},
+	Initializing: {Running, Complete, ExecutorError, SystemErrorState, Canceled},
+	Running:      {Complete, ExecutorError, SystemErrorState, Canceled},
+}
+
+// CanTransition returns true if a task may move from state s to state to.
+func (s State) CanTransition(to State) bool {
+	for _, t := range transitions[s] {
+		if t == to {
+			return true
+		}
+	}
+	return false
+}
+
+// FinalState returns the state of a task whose Run returned err.
+func FinalState(err error) State {
+	if err == nil {
+		return Complete
+	}
+
+	errs, ok := err.(MultiError)
+	if !ok {
+		errs = MultiError{err}
+	}
+
+	state := SystemErrorState
+	for _, e := range errs {
+		switch e.(type) {
+		case *CanceledError:
+			return Canceled
+		case *ExecError, *TimeoutError:
+			state = ExecutorError
+		}
+	}
+	return state
+}
+
+// StateLogger tracks the state of a task through the Logger callbacks
+// made by Run, and calls OnChange on each transition:
+//
+//   - StartTime moves the task from Queued to Initializing.
+//   - The first Running moves it to Running.
+//   - EndTime moves it to the final state given to Finished,
+//     or to Complete if Finished wasn't called.
+//
+// Callbacks which don't match a valid transition, e.g. a second
+// StartTime, don't change the state. Every callback is passed on
+// to the wrapped Logger.
+type StateLogger struct {
+	Logger
+	// OnChange is called after each transition, if set.
+	OnChange func(from, to State)
+
+	mu    sync.Mutex
+	state State
+	final State
+}
+
+// NewStateLogger returns a StateLogger, in the Queued state,
+// which wraps log.
+func NewStateLogger(log Logger, onChange func(from, to State)) *StateLogger {
+	return &StateLogger{Logger: log, OnChange: onChange, state: Queued}
+}
+
+// State returns the current state of the task.
+func (s *StateLogger) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+func (s *StateLogger) transition(to State) {
+	s.mu.Lock()
+	from := s.state
+	ok := from.CanTransition(to)
+	if ok {
+		s.state = to
+	}
+	s.mu.Unlock()
+
+	if ok && s.OnChange != nil {
+		s.OnChange(from, to)
+	}
+}
+
+func (s *StateLogger) StartTime(t time.Time) {
+	s.Logger.StartTime(t)
+	s.transition(Initializing)
+}
+
+func (s *StateLogger) Running() {
+	s.Logger.Running()
+	s.transition(Running)
+}
+
+// Finished records the final state, which the task moves to at EndTime.
+func (s *StateLogger) Finished(state State, err error) {
+	s.mu.Lock()
+	s.final = state
+	s.mu.Unlock()
+	s.Logger.Finished(state, err)
+}
+
+// EndTime moves the task to its final state before passing the
+// end time on, so the wrapped Logger learns the state before the end.
+func (s *StateLogger) EndTime(t time.Time) {
+	s.mu.Lock()
+	final := s.final
+	s.mu.Unlock()
+	if final == "" {
+		final = Complete
+	}
+	s.transition(final)
+
+	s.Logger.EndTime(t)
+}
@@ -0,0 +1,111 @@
+package tugboat
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to State
+		ok       bool
+	}{
+		{Queued, Initializing, true},
+		{Queued, Canceled, true},
+		{Queued, Running, false},
+		{Queued, Complete, false},
+		{Initializing, Running, true},
+		{Initializing, SystemErrorState, true},
+		{Initializing, Queued, false},
+		{Running, Complete, true},
+		{Running, ExecutorError, true},
+		{Running, Initializing, false},
+		{Running, Running, false},
+		{Complete, Running, false},
+		{Canceled, Complete, false},
+	}
+	for _, test := range tests {
+		if got := test.from.CanTransition(test.to); got != test.ok {
+			t.Errorf("%s -> %s: expected %v, got %v", test.from, test.to, test.ok, got)
+		}
+	}
+}
+
+func TestStateLogger(t *testing.T) {
+	failed := errors.New("failed")
+	tests := []struct {
+		name     string
+		events   func(l Logger)
+		expected []State
+	}{
+		{
+			name: "complete",
+			events: func(l Logger) {
+				l.StartTime(time.Now())
+				l.Running()
+				l.Exited(0)
+				l.Finished(Complete, nil)
+				l.EndTime(time.Now())
+			},
+			expected: []State{Initializing, Running, Complete},
+		},
+		{
+			name: "several commands",
+			events: func(l Logger) {
+				l.StartTime(time.Now())
+				l.Running()
+				l.Exited(1)
+				l.Running()
+				l.Exited(0)
+				l.Finished(ExecutorError, failed)
+				l.EndTime(time.Now())
+			},
+			expected: []State{Initializing, Running, ExecutorError},
+		},
+		{
+			name: "failed download",
+			events: func(l Logger) {
+				l.StartTime(time.Now())
+				l.Finished(SystemErrorState, failed)
+				l.EndTime(time.Now())
+			},
+			expected: []State{Initializing, SystemErrorState},
+		},
+		{
+			name: "no outcome",
+			events: func(l Logger) {
+				l.StartTime(time.Now())
+				l.EndTime(time.Now())
+			},
+			expected: []State{Initializing, Complete},
+		},
+		{
+			name: "events after the end",
+			events: func(l Logger) {
+				l.StartTime(time.Now())
+				l.Finished(Canceled, failed)
+				l.EndTime(time.Now())
+				l.StartTime(time.Now())
+				l.Running()
+			},
+			expected: []State{Initializing, Canceled},
+		},
+	}
+
+	quiet := &recordLogger{}
+	for _, test := range tests {
+		var states []State
+		sl := NewStateLogger(quiet, func(from, to State) {
+			states = append(states, to)
+		})
+		test.events(sl)
+		if !reflect.DeepEqual(states, test.expected) {
+			t.Errorf("%s: unexpected states: %v", test.name, states)
+		}
+		if last := test.expected[len(test.expected)-1]; sl.State() != last {
+			t.Errorf("%s: unexpected final state: %s", test.name, sl.State())
+		}
+	}
+}
@@ -9,17 +9,26 @@ import (
 type Stdio struct {
 	Stdin          io.Reader
 	Stdout, Stderr io.Writer
+
+	// files opened by NewStdio, which might be hidden
+	// from Close by wrapping Stdout/Stderr, e.g. in LogStdio.
+	files []*os.File
 }
 
 func (s *Stdio) Close() error {
 	var errors MultiError
-	if closer, ok := s.Stdout.(io.WriteCloser); ok {
+	closed := map[interface{}]bool{}
+	for _, f := range s.files {
+		errors.Try(f.Close())
+		closed[f] = true
+	}
+	if closer, ok := s.Stdout.(io.WriteCloser); ok && !closed[closer] {
 		err := closer.Close()
 		if err != nil {
 			errors = append(errors, err)
 		}
 	}
-	if closer, ok := s.Stderr.(io.WriteCloser); ok {
+	if closer, ok := s.Stderr.(io.WriteCloser); ok && !closed[closer] {
 		err := closer.Close()
 		if err != nil {
 			errors = append(errors, err)
@@ -52,6 +61,7 @@ func NewStdio(stdin, stdout, stderr string) (*Stdio, error) {
 			return nil, wrap(err, "failed to open stdin file")
 		}
 		stdio.Stdin = s
+		stdio.files = append(stdio.files, s)
 	}
 
 	if stdout != "" {
@@ -60,6 +70,7 @@ func NewStdio(stdin, stdout, stderr string) (*Stdio, error) {
 			return nil, wrap(err, "failed to create stdout file")
 		}
 		stdio.Stdout = s
+		stdio.files = append(stdio.files, s)
 	}
 
 	if stderr != "" {
@@ -68,6 +79,7 @@ func NewStdio(stdin, stdout, stderr string) (*Stdio, error) {
 			return nil, wrap(err, "failed to create stderr file")
 		}
 		stdio.Stderr = s
+		stdio.files = append(stdio.files, s)
 	}
 	return stdio, nil
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 )
 
@@ -17,8 +18,22 @@ type Storage interface {
 	SupportsPut(url string) bool
 }
 
+// Download fetches the task's inputs into the stage.
+//
+// If the stage is checkpointed, each finished download is recorded in the
+// stage directory, and inputs recorded by an earlier run of the task are
+// skipped, if they're unchanged.
 func Download(ctx context.Context, task *StagedTask, store Storage, log Logger) error {
 
+	var cps *checkpoints
+	if task.Checkpoint {
+		var err error
+		cps, err = loadCheckpoints(task.Dir)
+		if err != nil {
+			return err
+		}
+	}
+
 	errors := make(chan error)
 	files := make(chan File)
 	done := make(chan struct{})
@@ -32,13 +47,33 @@ func Download(ctx context.Context, task *StagedTask, store Storage, log Logger)
 			defer wg.Done()
 
 			for file := range files {
+				if cps != nil {
+					if cps.done(file) {
+						log.Info("input already downloaded", "url", file.URL, "path", file.Path)
+						continue
+					}
+					// Remove what's left of an interrupted download,
+					// since storage may not truncate an existing file.
+					if err := os.Remove(file.Path); err != nil && !os.IsNotExist(err) {
+						errors <- wrap(err, "failed to remove partial download %s", file.Path)
+						continue
+					}
+				}
+
 				log.DownloadStarted(file)
-				// TODO log bytes copied
 
 				err := store.Get(ctx, file.URL, file.Path)
 				if err != nil {
 					errors <- wrap(err, "download failed %s, %s", file.URL, file.Path)
 				} else {
+					if info, err := os.Stat(file.Path); err == nil {
+						file.Size = info.Size()
+					}
+					if cps != nil {
+						if err := cps.add(file); err != nil {
+							errors <- wrap(err, "failed to checkpoint download %s", file.URL)
+						}
+					}
 					log.DownloadFinished(file)
 				}
 			}
@@ -81,17 +116,21 @@ func Upload(ctx context.Context, task *StagedTask, store Storage, log Logger) er
 			defer wg.Done()
 
 			for file := range files {
-				log.UploadStarted(file.out)
+				logged := File{
+					URL:  joinURL(file.out.URL, file.rel),
+					Path: file.path,
+					Size: file.size,
+				}
+				log.UploadStarted(logged)
 
 				// TODO
 				//r.fixLinks(mapper, output.Path)
-				// TODO log bytes copied
 
 				err := store.Put(ctx, file.out.URL, file.rel, file.path)
 				if err != nil {
 					errors <- wrap(err, "uploading %q to %q", file.path, file.out.URL)
 				} else {
-					log.UploadFinished(file.out)
+					log.UploadFinished(logged)
 				}
 			}
 		}()
@@ -120,6 +159,15 @@ func Upload(ctx context.Context, task *StagedTask, store Storage, log Logger) er
 	return me.Finish()
 }
 
+// joinURL returns the URL of a file at the relative path rel
+// inside the output directory at url.
+func joinURL(url, rel string) string {
+	if rel == "." || rel == "" {
+		return url
+	}
+	return strings.TrimSuffix(url, "/") + "/" + filepath.ToSlash(rel)
+}
+
 type hostfile struct {
 	out File
 	rel string
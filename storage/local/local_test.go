@@ -0,0 +1,68 @@
+package local
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	tug "github.com/buchanae/tugboat"
+)
+
+// ownerExec records the owner of the staged input.
+type ownerExec struct {
+	owner int
+}
+
+func (o *ownerExec) Exec(ctx context.Context, task *tug.StagedTask, stdio *tug.Stdio) error {
+	info, err := os.Stat(task.Inputs[0].Path)
+	if err != nil {
+		return err
+	}
+	o.owner = int(info.Sys().(*syscall.Stat_t).Uid)
+	return nil
+}
+
+// A hard-linked input shares its inode with the source file, so giving
+// the stage to the task's user mustn't change the source's owner.
+func TestLinkedInputOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing file ownership needs root")
+	}
+
+	src := filepath.Join(t.TempDir(), "in.txt")
+	if err := os.WriteFile(src, []byte("in"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The source belongs to neither the worker nor the task's user.
+	if err := os.Chown(src, 4321, 4321); err != nil {
+		t.Fatal(err)
+	}
+	stage, err := tug.NewStage(t.TempDir(), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := &tug.Task{
+		ID:     "linked",
+		User:   "1234:1234",
+		Inputs: []tug.File{{URL: src, Path: "/inputs/in.txt"}},
+	}
+	exec := &ownerExec{owner: -1}
+	log := tug.EmptyLogger{Level: tug.ErrorLevel + 1}
+	if _, err := tug.Run(context.Background(), task, stage, log, &Local{}, exec); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := int(info.Sys().(*syscall.Stat_t).Uid); uid != 4321 {
+		t.Errorf("expected the source file to keep its owner, got %d", uid)
+	}
+	if exec.owner != 4321 {
+		t.Errorf("expected the linked input to keep its owner, got %d", exec.owner)
+	}
+}
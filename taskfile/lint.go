@@ -0,0 +1,231 @@
+package taskfile
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	tug "github.com/buchanae/tugboat"
+)
+
+// Severity is the severity of a Diagnostic.
+type Severity string
+
+const (
+	// SeverityError marks a problem which would make the task fail.
+	SeverityError Severity = "error"
+	// SeverityWarning marks something which is likely a mistake.
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a problem found by Lint.
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	*Error
+}
+
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s (%s)", d.Severity, d.Error.Error(), d.Code)
+}
+
+// LintOptions configures Lint.
+type LintOptions struct {
+	// Storage is the storage backend the tasks will run with,
+	// "local" or "gs". URLs the backend can't handle are reported.
+	// If empty, URLs aren't checked.
+	Storage string
+	// Params are substituted into the tasks before they're checked.
+	// Placeholders which aren't in Params are reported.
+	Params Params
+}
+
+// storageSchemes lists the URL schemes supported by each storage backend.
+// Plain paths have the "file" scheme.
+var storageSchemes = map[string][]string{
+	"local": {"file"},
+	"gs":    {"gs"},
+}
+
+// Lint checks task files for problems, without running anything:
+// undefined params, invalid tasks, task IDs which are unsafe as stage
+// directory names, e.g. "../x", task IDs used more than once, container paths which are
+// relative or escape the stage directory, outputs which aren't in a volume,
+// and URLs the storage backend doesn't support.
+func Lint(files []*File, opts LintOptions) ([]*Diagnostic, error) {
+	var schemes []string
+	if opts.Storage != "" {
+		var ok bool
+		schemes, ok = storageSchemes[opts.Storage]
+		if !ok {
+			return nil, fmt.Errorf("unknown storage backend %q", opts.Storage)
+		}
+	}
+
+	l := &linter{storage: opts.Storage, schemes: schemes}
+	// ids maps task IDs to the location where they're first used.
+	ids := map[string]string{}
+
+	for _, f := range files {
+		l.f = f
+		if err := f.Substitute(opts.Params); err != nil {
+			for _, e := range err.(ErrorList) {
+				l.add(SeverityError, e)
+			}
+		}
+		if err := f.Validate(); err != nil {
+			for _, e := range err.(ErrorList) {
+				l.add(SeverityError, e)
+			}
+		}
+
+		id := f.Task.ID
+		if prev, ok := ids[id]; ok && id != "" {
+			l.report(SeverityError, "duplicate-id", "id", "duplicate task ID %q, also used at %s", id, prev)
+		} else if id != "" {
+			ids[id] = location(f.Errorf("", "id", ""))
+		}
+
+		l.task(f.Task)
+	}
+	return l.diags, nil
+}
+
+// location returns the "file:line:column" of an error.
+func location(e *Error) string {
+	return fmt.Sprintf("%s:%d:%d", e.File, e.Line, e.Column)
+}
+
+type linter struct {
+	f       *File
+	storage string
+	schemes []string
+	diags   []*Diagnostic
+}
+
+func (l *linter) add(s Severity, e *Error) {
+	l.diags = append(l.diags, &Diagnostic{Severity: s, Error: e})
+}
+
+func (l *linter) report(s Severity, code, field, msg string, args ...interface{}) {
+	l.add(s, l.f.Errorf(code, field, msg, args...))
+}
+
+func (l *linter) task(t *tug.Task) {
+	// Stdio files are staged on the host and aren't mounted
+	// into the container, so they may be relative.
+	stdio := map[string]bool{}
+	l.stdio("", t.Stdin, t.Stdout, t.Stderr, stdio)
+	for i, e := range t.Executors {
+		l.stdio(fmt.Sprintf("executors[%d].", i), e.Stdin, e.Stdout, e.Stderr, stdio)
+	}
+
+	for i, v := range t.Volumes {
+		l.containerPath(fmt.Sprintf("volumes[%d]", i), v)
+	}
+	if t.Workdir != "" {
+		l.containerPath("workdir", t.Workdir)
+	}
+	for i, e := range t.Executors {
+		if e.Workdir != "" {
+			l.containerPath(fmt.Sprintf("executors[%d].workdir", i), e.Workdir)
+		}
+	}
+
+	for i, in := range t.Inputs {
+		field := fmt.Sprintf("inputs[%d]", i)
+		l.containerPath(field+".path", in.Path)
+		l.url(field+".url", in.URL)
+	}
+
+	for i, out := range t.Outputs {
+		field := fmt.Sprintf("outputs[%d]", i)
+		l.url(field+".url", out.URL)
+		if stdio[path.Clean(out.Path)] {
+			l.escaping(field+".path", out.Path)
+			continue
+		}
+		if !l.containerPath(field+".path", out.Path) {
+			continue
+		}
+		if !inVolume(out.Path, t.Volumes) {
+			l.report(SeverityError, "output-outside-volume", field+".path",
+				"%s is not in a volume, so the command can't write it", out.Path)
+		}
+	}
+}
+
+func (l *linter) stdio(prefix, stdin, stdout, stderr string, paths map[string]bool) {
+	for _, s := range []struct{ field, path string }{
+		{"stdin", stdin}, {"stdout", stdout}, {"stderr", stderr},
+	} {
+		if s.path == "" {
+			continue
+		}
+		l.escaping(prefix+s.field, s.path)
+		paths[path.Clean(s.path)] = true
+	}
+}
+
+// containerPath checks a path which is mounted into the container.
+// It returns false if the path has a problem.
+func (l *linter) containerPath(field, p string) bool {
+	if p == "" {
+		return false
+	}
+	if !path.IsAbs(p) {
+		l.report(SeverityError, "relative-path", field,
+			"%s must be an absolute path in the container", p)
+		return false
+	}
+	return l.escaping(field, p)
+}
+
+// escaping checks that the path stays inside the stage directory
+// when it's staged, as Stage.Map requires.
+// It returns false if the path escapes.
+func (l *linter) escaping(field, p string) bool {
+	const root = "/stage"
+	mapped := path.Join(root, p)
+	if mapped != root && !strings.HasPrefix(mapped, root+"/") {
+		l.report(SeverityError, "escaping-path", field,
+			"%s is outside the stage directory", p)
+		return false
+	}
+	return true
+}
+
+func (l *linter) url(field, raw string) {
+	if raw == "" || l.schemes == nil {
+		return
+	}
+
+	scheme := "file"
+	if u, err := url.Parse(raw); err == nil && u.Scheme != "" {
+		scheme = u.Scheme
+	}
+
+	for _, s := range l.schemes {
+		if s == scheme {
+			if scheme == "file" && !path.IsAbs(strings.TrimPrefix(raw, "file://")) {
+				l.report(SeverityWarning, "relative-url", field,
+					"%s is relative to the directory tug runs in", raw)
+			}
+			return
+		}
+	}
+	l.report(SeverityError, "unsupported-url", field,
+		"%s storage doesn't support %q URLs", l.storage, scheme)
+}
+
+// inVolume returns true if p is in one of the volumes.
+func inVolume(p string, volumes []string) bool {
+	p = path.Clean(p)
+	for _, v := range volumes {
+		v = path.Clean(v)
+		if p == v || strings.HasPrefix(p, strings.TrimSuffix(v, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
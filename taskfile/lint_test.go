@@ -0,0 +1,69 @@
+package taskfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	a, err := Decode("a.yaml", []byte(`
+id: md5
+containerImage: alpine
+command: [md5sum]
+stdout: out.txt
+volumes: [/outputs]
+inputs:
+  - url: gs://bucket/in.txt
+    path: inputs/in.txt
+outputs:
+  - url: /data/out.txt
+    path: out.txt
+  - url: /data/results
+    path: /results/md5.txt
+  - url: /data/escaped
+    path: /outputs/../../etc/passwd
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Decode("b.yaml", []byte(`
+id: md5
+command: [md5sum]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Decode("c.yaml", []byte(`
+id: ../etc
+containerImage: alpine
+command: [ls]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diags, err := Lint([]*File{a, b, c}, LintOptions{Storage: "local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, d := range diags {
+		got = append(got, d.String())
+	}
+	expected := []string{
+		`error: a.yaml:9:5: inputs[0].path: inputs/in.txt must be an absolute path in the container (relative-path)`,
+		`error: a.yaml:8:5: inputs[0].url: local storage doesn't support "gs" URLs (unsupported-url)`,
+		`error: a.yaml:14:5: outputs[1].path: /results/md5.txt is not in a volume, so the command can't write it (output-outside-volume)`,
+		`error: a.yaml:16:5: outputs[2].path: /outputs/../../etc/passwd is outside the stage directory (escaping-path)`,
+		`error: b.yaml:2:1: containerImage: required (missing-image)`,
+		`error: b.yaml:2:1: id: duplicate task ID "md5", also used at a.yaml:2:1 (duplicate-id)`,
+		`error: c.yaml:2:1: id: invalid task ID "../etc": task IDs must start with a letter or digit, and may only contain letters, digits, ".", "_" and "-" (invalid-id)`,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected diagnostics:\n%s", strings.Join(got, "\n"))
+	}
+}
@@ -0,0 +1,103 @@
+package taskfile
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Params are the values of "${params.NAME}" placeholders in a task file.
+// Placeholders are allowed in the ID, command, env values, and input
+// and output URLs and paths of a task, and in the command and env values
+// of its executors. Placing a param in the ID, e.g. "align-${params.sample}",
+// gives each task made from a template its own ID. Other "${...}"
+// expressions, e.g. shell variables, are left alone.
+type Params map[string]string
+
+var paramRx = regexp.MustCompile(`\$\{params\.([A-Za-z0-9_.-]+)\}`)
+
+// LoadParams reads params from a YAML or JSON file
+// holding a mapping of names to values.
+func LoadParams(path string) (Params, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	params := Params{}
+	if err := yaml.Unmarshal(data, &params); err != nil {
+		return nil, decodeError(path, err)
+	}
+	return params, nil
+}
+
+// Substitute replaces the placeholders in the task with params,
+// returning an ErrorList locating any placeholders which aren't in params.
+func (f *File) Substitute(params Params) error {
+	s := &substituter{f: f, params: params}
+	t := f.Task
+
+	s.replace("id", &t.ID)
+	s.command("command", t.Command)
+	s.env("env", t.Env)
+	for i := range t.Inputs {
+		s.replace(fmt.Sprintf("inputs[%d].url", i), &t.Inputs[i].URL)
+		s.replace(fmt.Sprintf("inputs[%d].path", i), &t.Inputs[i].Path)
+	}
+	for i := range t.Outputs {
+		s.replace(fmt.Sprintf("outputs[%d].url", i), &t.Outputs[i].URL)
+		s.replace(fmt.Sprintf("outputs[%d].path", i), &t.Outputs[i].Path)
+	}
+	for i := range t.Executors {
+		field := fmt.Sprintf("executors[%d]", i)
+		s.command(field+".command", t.Executors[i].Command)
+		s.env(field+".env", t.Executors[i].Env)
+	}
+
+	if len(s.errs) == 0 {
+		return nil
+	}
+	return s.errs
+}
+
+type substituter struct {
+	f      *File
+	params Params
+	errs   ErrorList
+}
+
+func (s *substituter) command(field string, cmd []string) {
+	for i := range cmd {
+		s.replace(fmt.Sprintf("%s[%d]", field, i), &cmd[i])
+	}
+}
+
+func (s *substituter) env(field string, env map[string]string) {
+	// Sort the keys, so errors are in a stable order.
+	var keys []string
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := env[k]
+		s.replace(field+"."+k, &v)
+		env[k] = v
+	}
+}
+
+func (s *substituter) replace(field string, val *string) {
+	*val = paramRx.ReplaceAllStringFunc(*val, func(m string) string {
+		name := paramRx.FindStringSubmatch(m)[1]
+		v, ok := s.params[name]
+		if !ok {
+			s.errs = append(s.errs, s.f.Errorf("undefined-param", field, "undefined parameter %q", name))
+			return m
+		}
+		return v
+	})
+}
@@ -0,0 +1,139 @@
+package taskfile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	tug "github.com/buchanae/tugboat"
+)
+
+func TestSubstitute(t *testing.T) {
+	f, err := Decode("task.yaml", []byte(`
+id: align
+containerImage: bwa
+command: [sh, -c, "bwa mem ref.fa ${params.sample}.fq > $HOME/${OUT}"]
+env:
+  SAMPLE: ${params.sample}
+inputs:
+  - url: gs://bucket/${params.cohort}/${params.sample}.fq
+    path: /inputs/${params.sample}.fq
+outputs:
+  - url: gs://bucket/${params.cohort}/${params.sample}.bam
+    path: /outputs/out.bam
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Substitute(Params{"sample": "NA12878", "cohort": "1kg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := f.Task
+	expected := []string{"sh", "-c", "bwa mem ref.fa NA12878.fq > $HOME/${OUT}"}
+	if !reflect.DeepEqual(task.Command, expected) {
+		t.Errorf("unexpected command %q", task.Command)
+	}
+	if task.Env["SAMPLE"] != "NA12878" {
+		t.Errorf("unexpected env %v", task.Env)
+	}
+	in := tug.File{URL: "gs://bucket/1kg/NA12878.fq", Path: "/inputs/NA12878.fq"}
+	if task.Inputs[0] != in {
+		t.Errorf("unexpected input %+v", task.Inputs[0])
+	}
+	if task.Outputs[0].URL != "gs://bucket/1kg/NA12878.bam" {
+		t.Errorf("unexpected output URL %s", task.Outputs[0].URL)
+	}
+}
+
+func TestSubstituteCohort(t *testing.T) {
+	template := []byte(`
+id: align-${params.sample}
+containerImage: bwa
+command:
+  - bwa
+  - mem
+  - ref.fa
+  - /inputs/${params.sample}.fq
+inputs:
+  - url: gs://bucket/${params.sample}.fq
+    path: /inputs/${params.sample}.fq
+outputs:
+  - url: gs://bucket/${params.sample}.bam
+    path: /outputs/${params.sample}.bam
+volumes: [/outputs]
+`)
+
+	var tasks []*tug.Task
+	for _, sample := range []string{"NA12878", "NA12891"} {
+		f, err := Decode("task.yaml", template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Substitute(Params{"sample": sample}); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		tasks = append(tasks, f.Task)
+	}
+
+	var ids, paths []string
+	for _, task := range tasks {
+		ids = append(ids, task.ID)
+		paths = append(paths, task.Inputs[0].Path, task.Outputs[0].Path)
+	}
+	if !reflect.DeepEqual(ids, []string{"align-NA12878", "align-NA12891"}) {
+		t.Errorf("unexpected IDs %q", ids)
+	}
+	expected := []string{
+		"/inputs/NA12878.fq", "/outputs/NA12878.bam",
+		"/inputs/NA12891.fq", "/outputs/NA12891.bam",
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("unexpected paths %q", paths)
+	}
+}
+
+func TestSubstituteUndefined(t *testing.T) {
+	f, err := Decode("task.yaml", []byte(`
+id: align
+containerImage: bwa
+command: [bwa, mem, "${params.sample}.fq"]
+env:
+  COHORT: ${params.cohort}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs, ok := f.Substitute(Params{"sample": "NA12878"}).(ErrorList)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	expected := `task.yaml:6:3: env.COHORT: undefined parameter "cohort"`
+	if errs[0].Error() != expected {
+		t.Errorf("unexpected error %q", errs[0].Error())
+	}
+}
+
+func TestLoadParams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "params.yaml")
+	err := os.WriteFile(path, []byte("sample: NA12878\nlane: 3\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := LoadParams(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Params{"sample": "NA12878", "lane": "3"}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("unexpected params %v", params)
+	}
+}
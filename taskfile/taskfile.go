@@ -0,0 +1,373 @@
+// Package taskfile loads task definitions from YAML or JSON files.
+//
+// A task file holds a single task, with fields named after the fields
+// of tugboat.Task in lowerCamelCase, e.g.
+//
+//	id: md5
+//	containerImage: alpine
+//	command: [md5sum, /inputs/in.txt]
+//	stdout: /outputs/md5.txt
+//	volumes: [/outputs]
+//	inputs:
+//	  - url: /data/in.txt
+//	    path: /inputs/in.txt
+//	outputs:
+//	  - url: /data/results
+//	    path: /outputs/md5.txt
+//	timeout: 10m
+//
+// JSON is a subset of YAML, so JSON files are loaded the same way.
+// Durations are strings, such as "90s" or "1h30m".
+//
+// A task file can be a template for many tasks, with "${params.NAME}"
+// placeholders filled in from Params. See Params for where they're allowed.
+package taskfile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tug "github.com/buchanae/tugboat"
+	"gopkg.in/yaml.v3"
+)
+
+// Error describes a problem with a task file, at a location in the file.
+// Line and Column are 1-based, and are zero when the location is unknown.
+type Error struct {
+	File   string `json:"file"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+	// Field is the path of the field with the problem,
+	// e.g. "inputs[0].path".
+	Field string `json:"field,omitempty"`
+	// Code identifies the kind of problem, e.g. "required".
+	Code string `json:"code,omitempty"`
+	Msg  string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	loc := e.File
+	if e.Line > 0 {
+		loc += ":" + strconv.Itoa(e.Line)
+		if e.Column > 0 {
+			loc += ":" + strconv.Itoa(e.Column)
+		}
+	}
+	if e.Field != "" {
+		return fmt.Sprintf("%s: %s: %s", loc, e.Field, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", loc, e.Msg)
+}
+
+// ErrorList is a list of errors found in a task file.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	var s []string
+	for _, e := range l {
+		s = append(s, e.Error())
+	}
+	return strings.Join(s, "\n")
+}
+
+// File is a decoded task file.
+type File struct {
+	Name string
+	Task *tug.Task
+	// root is the document node, used to find the location of fields.
+	root *yaml.Node
+	// offset is added to line numbers, for tasks decoded
+	// from a line of a larger file.
+	offset int
+}
+
+// Load reads and decodes the task file at path, substitutes params
+// into its placeholders, and validates the task.
+func Load(path string, params Params) (*tug.Task, error) {
+	f, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Substitute(params); err != nil {
+		return nil, err
+	}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return f.Task, nil
+}
+
+// ReadFile reads and decodes the task file at path, without validating it.
+func ReadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(path, data)
+}
+
+// Decode decodes a task from YAML or JSON data. Unknown fields
+// are an error. name is the file name used in errors.
+func Decode(name string, data []byte) (*File, error) {
+	f := &File{Name: name, Task: &tug.Task{}}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err := dec.Decode(f.Task)
+	if err == io.EOF {
+		return nil, &Error{File: name, Code: "syntax", Msg: "empty task file"}
+	}
+	if err != nil {
+		return nil, decodeError(name, err)
+	}
+
+	var extra yaml.Node
+	if err := dec.Decode(&extra); err != io.EOF {
+		return nil, &Error{File: name, Line: extra.Line, Code: "syntax", Msg: "expected a single task, found multiple documents"}
+	}
+
+	f.root = &yaml.Node{}
+	if err := yaml.Unmarshal(data, f.root); err != nil {
+		return nil, decodeError(name, err)
+	}
+	return f, nil
+}
+
+// ReadLines reads a JSON Lines file, holding one task per line.
+func ReadLines(path string) ([]*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeLines(path, data)
+}
+
+// DecodeLines decodes one task per line of data. Blank lines are skipped.
+// Errors are located by their line in data. name is the file name used in errors.
+func DecodeLines(name string, data []byte) ([]*File, error) {
+	var files []*File
+	var errs ErrorList
+
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		f, err := Decode(name, line)
+		if err != nil {
+			for _, e := range errorList(err) {
+				if e.Line == 0 {
+					e.Line = 1
+				}
+				e.Line += i
+				errs = append(errs, e)
+			}
+			continue
+		}
+		f.offset = i
+		files = append(files, f)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return files, nil
+}
+
+// errorList converts an *Error or ErrorList to an ErrorList.
+func errorList(err error) ErrorList {
+	switch e := err.(type) {
+	case ErrorList:
+		return e
+	case *Error:
+		return ErrorList{e}
+	}
+	return ErrorList{{Msg: err.Error()}}
+}
+
+var lineRx = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// decodeError converts a yaml error to an ErrorList,
+// pulling out the line numbers yaml includes in its messages.
+func decodeError(name string, err error) error {
+	var msgs []string
+	if te, ok := err.(*yaml.TypeError); ok {
+		msgs = te.Errors
+	} else {
+		msgs = []string{err.Error()}
+	}
+
+	var errs ErrorList
+	for _, msg := range msgs {
+		e := &Error{File: name, Code: "syntax", Msg: strings.TrimPrefix(msg, "yaml: ")}
+		if m := lineRx.FindStringSubmatch(msg); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Msg = m[2]
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// Validate checks that the task is complete and consistent,
+// returning an ErrorList describing any problems.
+func (f *File) Validate() error {
+	v := &validator{f: f}
+	v.task(f.Task)
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// Errorf returns an error located at the given field of the task file,
+// e.g. "inputs[0].url". If the field isn't in the file, the error
+// is located at the closest enclosing field that is.
+func (f *File) Errorf(code, field, msg string, args ...interface{}) *Error {
+	e := &Error{File: f.Name, Field: field, Code: code, Msg: fmt.Sprintf(msg, args...)}
+	if n := f.locate(field); n != nil {
+		e.Line, e.Column = n.Line+f.offset, n.Column
+	}
+	return e
+}
+
+var segmentRx = regexp.MustCompile(`([^.\[\]]+)|\[(\d+)\]`)
+
+// locate finds the yaml node of a field path, such as "inputs[0].url".
+// For fields in a mapping, the node of the key is returned,
+// so the location points at the field's name.
+func (f *File) locate(field string) *yaml.Node {
+	if f.root == nil || len(f.root.Content) == 0 {
+		return nil
+	}
+	node := f.root.Content[0]
+	found := node
+
+	for _, m := range segmentRx.FindAllStringSubmatch(field, -1) {
+		var next *yaml.Node
+
+		switch {
+		case m[1] != "" && node.Kind == yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == m[1] {
+					found = node.Content[i]
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case m[2] != "" && node.Kind == yaml.SequenceNode:
+			i, _ := strconv.Atoi(m[2])
+			if i < len(node.Content) {
+				next = node.Content[i]
+				found = next
+			}
+		}
+
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return found
+}
+
+type validator struct {
+	f    *File
+	errs ErrorList
+}
+
+func (v *validator) errorf(code, field, msg string, args ...interface{}) {
+	v.errs = append(v.errs, v.f.Errorf(code, field, msg, args...))
+}
+
+func (v *validator) required(field, val string) {
+	if strings.TrimSpace(val) == "" {
+		v.errorf("required", field, "required")
+	}
+}
+
+// image checks that a container image is set.
+func (v *validator) image(field, val string) {
+	if strings.TrimSpace(val) == "" {
+		v.errorf("missing-image", field, "required")
+	}
+}
+
+func (v *validator) task(t *tug.Task) {
+	v.required("id", t.ID)
+	if t.ID != "" {
+		if err := tug.ValidateID(t.ID); err != nil {
+			v.errorf("invalid-id", "id", "%s", err)
+		}
+	}
+
+	if len(t.Executors) == 0 {
+		v.image("containerImage", t.ContainerImage)
+		if len(t.Command) == 0 {
+			v.errorf("required", "command", "required")
+		}
+	} else {
+		if t.ContainerImage != "" || len(t.Command) != 0 {
+			v.errorf("conflict", "executors", "containerImage and command can't be used with executors")
+		}
+		if t.Stdin != "" || t.Stdout != "" || t.Stderr != "" {
+			v.errorf("conflict", "executors", "stdin, stdout and stderr can't be used with executors; set them on each executor")
+		}
+	}
+
+	for i, e := range t.Executors {
+		field := fmt.Sprintf("executors[%d]", i)
+		v.image(field+".containerImage", e.ContainerImage)
+		if len(e.Command) == 0 {
+			v.errorf("required", field+".command", "required")
+		}
+	}
+
+	for i, vol := range t.Volumes {
+		v.required(fmt.Sprintf("volumes[%d]", i), vol)
+	}
+	v.files("inputs", t.Inputs)
+	v.files("outputs", t.Outputs)
+
+	names := map[string]bool{}
+	for i, s := range t.Services {
+		field := fmt.Sprintf("services[%d]", i)
+		v.required(field+".name", s.Name)
+		v.image(field+".containerImage", s.ContainerImage)
+		if names[s.Name] {
+			v.errorf("duplicate-name", field+".name", "duplicate service name %q", s.Name)
+		}
+		names[s.Name] = true
+		if s.HealthTimeout < 0 {
+			v.errorf("invalid-value", field+".healthTimeout", "must not be negative")
+		}
+	}
+
+	if t.User != "" {
+		if _, _, err := tug.ParseUser(t.User); err != nil {
+			v.errorf("invalid-value", "user", "%s", err)
+		}
+	}
+	if t.Timeout < 0 {
+		v.errorf("invalid-value", "timeout", "must not be negative")
+	}
+	if r := t.Resources; r.CPUCores < 0 || r.RAMGB < 0 || r.DiskGB < 0 {
+		v.errorf("invalid-value", "resources", "must not be negative")
+	}
+}
+
+func (v *validator) files(field string, files []tug.File) {
+	for i, f := range files {
+		field := fmt.Sprintf("%s[%d]", field, i)
+		v.required(field+".url", f.URL)
+		v.required(field+".path", f.Path)
+		if f.Size != 0 {
+			v.errorf("invalid-value", field+".size", "size is set by tugboat and can't be given in a task file")
+		}
+	}
+}
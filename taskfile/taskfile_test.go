@@ -0,0 +1,132 @@
+package taskfile
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+)
+
+func TestDecode(t *testing.T) {
+	data := []byte(`
+id: md5
+containerImage: alpine
+command: [md5sum, /inputs/in.txt]
+stdout: /outputs/md5.txt
+volumes: [/outputs]
+env:
+  FOO: bar
+inputs:
+  - url: /data/in.txt
+    path: /inputs/in.txt
+outputs:
+  - url: /data/results
+    path: /outputs/md5.txt
+timeout: 10m
+`)
+	f, err := Decode("task.yaml", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &tug.Task{
+		ID:             "md5",
+		ContainerImage: "alpine",
+		Command:        []string{"md5sum", "/inputs/in.txt"},
+		Stdout:         "/outputs/md5.txt",
+		Volumes:        []string{"/outputs"},
+		Env:            map[string]string{"FOO": "bar"},
+		Inputs:         []tug.File{{URL: "/data/in.txt", Path: "/inputs/in.txt"}},
+		Outputs:        []tug.File{{URL: "/data/results", Path: "/outputs/md5.txt"}},
+		Timeout:        tug.Duration(10 * time.Minute),
+	}
+	if !reflect.DeepEqual(f.Task, expected) {
+		t.Errorf("unexpected task %+v", f.Task)
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	data := []byte(`{
+  "id": "md5",
+  "containerImage": "alpine",
+  "command": ["md5sum", "/inputs/in.txt"]
+}`)
+	f, err := Decode("task.json", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Task.ID != "md5" || f.Task.ContainerImage != "alpine" {
+		t.Errorf("unexpected task %+v", f.Task)
+	}
+}
+
+func TestUnknownField(t *testing.T) {
+	data := []byte(`
+id: md5
+containerImage: alpine
+comand: [md5sum]
+`)
+	_, err := Decode("task.yaml", data)
+	errs, ok := err.(ErrorList)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if errs[0].Line != 4 {
+		t.Errorf("unexpected error line %d", errs[0].Line)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	data := []byte(`
+id: md5
+containerImage: alpine
+command: [md5sum]
+inputs:
+  - url: /data/in.txt
+user: root
+`)
+	f, err := Decode("task.yaml", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs, ok := f.Validate().(ErrorList)
+	if !ok {
+		t.Fatalf("expected an ErrorList")
+	}
+
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Error())
+	}
+	expected := []string{
+		"task.yaml:6:5: inputs[0].path: required",
+		`task.yaml:7:1: user: invalid user "root": expected "uid:gid"`,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected errors %q", got)
+	}
+}
+
+func TestValidateID(t *testing.T) {
+	tests := map[string]bool{
+		"md5":           true,
+		"align-NA12878": true,
+		"a.b_c-1":       true,
+		"../x":          false,
+		"a/../../etc":   false,
+		"a/b":           false,
+		".hidden":       false,
+		"-rf":           false,
+	}
+	for id, ok := range tests {
+		f := &File{Name: "task.yaml", Task: &tug.Task{ID: id, ContainerImage: "alpine", Command: []string{"ls"}}}
+		if err := f.Validate(); (err == nil) != ok {
+			t.Errorf("%q: unexpected error: %v", id, err)
+		}
+	}
+}
@@ -1,9 +0,0 @@
-package tugboat
-
-import (
-	"fmt"
-)
-
-func debug(msg string, args ...interface{}) {
-	fmt.Println(msg, args)
-}
@@ -0,0 +1,122 @@
+package tugboat
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Version struct {
+	Name, Doc                string
+	Major, Minor, Patch      int
+	Commit, Branch, Upstream string
+	Date                     time.Time
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%s %d.%d.%d", v.Name, v.Major, v.Minor, v.Patch)
+	var details []string
+	if v.Commit != "" {
+		details = append(details, "commit "+v.Commit)
+	}
+	if v.Branch != "" {
+		details = append(details, "branch "+v.Branch)
+	}
+	if !v.Date.IsZero() {
+		details = append(details, "built "+v.Date.Format(time.RFC3339))
+	}
+	if len(details) > 0 {
+		s += " (" + strings.Join(details, ", ") + ")"
+	}
+	return s
+}
+
+// These are set at build time with ldflags, e.g.
+//
+//	go build -ldflags "-X github.com/buchanae/tugboat.gitCommit=$(git rev-parse HEAD)"
+//
+// See the Makefile. Values which aren't set are taken from the
+// Go build info, where possible.
+var (
+	gitCommit   string
+	gitBranch   string
+	gitUpstream string
+	// buildDate is formatted as RFC 3339.
+	buildDate string
+	// semver is formatted as "v1.2.3" or "1.2.3".
+	semver string
+)
+
+var version = buildVersion()
+
+// BuildVersion returns the version of this build of tugboat.
+func BuildVersion() Version {
+	return version
+}
+
+func buildVersion() Version {
+	v := Version{
+		Name:     "tugboat",
+		Doc:      "https://github.com/buchanae/tugboat",
+		Commit:   gitCommit,
+		Branch:   gitBranch,
+		Upstream: gitUpstream,
+	}
+	if t, err := time.Parse(time.RFC3339, buildDate); err == nil {
+		v.Date = t
+	}
+	sv := semver
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if sv == "" {
+			sv = moduleVersion(info)
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if v.Commit == "" {
+					v.Commit = s.Value
+				}
+			case "vcs.time":
+				if t, err := time.Parse(time.RFC3339, s.Value); err == nil && v.Date.IsZero() {
+					v.Date = t
+				}
+			}
+		}
+	}
+
+	v.Major, v.Minor, v.Patch = parseSemver(sv)
+	return v
+}
+
+// moduleVersion returns the version of the tugboat module
+// in the build info, e.g. when tugboat is a dependency.
+func moduleVersion(info *debug.BuildInfo) string {
+	const path = "github.com/buchanae/tugboat"
+	if info.Main.Path == path {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			return dep.Version
+		}
+	}
+	return ""
+}
+
+// parseSemver parses "v1.2.3", ignoring any pre-release or build suffix.
+// Parts which are missing or invalid are zero.
+func parseSemver(s string) (major, minor, patch int) {
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexAny(s, "-+"); i != -1 {
+		s = s[:i]
+	}
+	parts := strings.SplitN(s, ".", 3)
+	nums := make([]int, 3)
+	for i, p := range parts {
+		nums[i], _ = strconv.Atoi(p)
+	}
+	return nums[0], nums[1], nums[2]
+}
@@ -0,0 +1,96 @@
+package tugboat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		s                   string
+		major, minor, patch int
+	}{
+		{"v1.2.3", 1, 2, 3},
+		{"1.2.3", 1, 2, 3},
+		{"v0.10.0-rc.1", 0, 10, 0},
+		{"v1.2.3+dirty", 1, 2, 3},
+		{"v1.2", 1, 2, 0},
+		{"(devel)", 0, 0, 0},
+		{"", 0, 0, 0},
+	}
+	for _, test := range tests {
+		major, minor, patch := parseSemver(test.s)
+		if major != test.major || minor != test.minor || patch != test.patch {
+			t.Errorf("%q: unexpected version %d.%d.%d", test.s, major, minor, patch)
+		}
+	}
+}
+
+func TestVersionString(t *testing.T) {
+	date := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		v        Version
+		expected string
+	}{
+		{Version{Name: "tugboat"}, "tugboat 0.0.0"},
+		{Version{Name: "tugboat", Major: 1, Minor: 2, Patch: 3, Commit: "abc"}, "tugboat 1.2.3 (commit abc)"},
+		{
+			Version{Name: "tugboat", Major: 1, Commit: "abc", Branch: "main", Date: date},
+			"tugboat 1.0.0 (commit abc, branch main, built 2018-01-02T03:04:05Z)",
+		},
+	}
+	for _, test := range tests {
+		if got := test.v.String(); got != test.expected {
+			t.Errorf("unexpected version string: %q", got)
+		}
+	}
+}
+
+func TestBuildVersion(t *testing.T) {
+	defer func(commit, branch, upstream, date, sv string) {
+		gitCommit, gitBranch, gitUpstream, buildDate, semver = commit, branch, upstream, date, sv
+	}(gitCommit, gitBranch, gitUpstream, buildDate, semver)
+
+	// Values set with ldflags take precedence over the build info.
+	gitCommit = "abc"
+	gitBranch = "main"
+	gitUpstream = "origin/main"
+	buildDate = "2018-01-02T03:04:05Z"
+	semver = "v1.2.3"
+
+	v := buildVersion()
+	expected := Version{
+		Name:     "tugboat",
+		Doc:      "https://github.com/buchanae/tugboat",
+		Major:    1,
+		Minor:    2,
+		Patch:    3,
+		Commit:   "abc",
+		Branch:   "main",
+		Upstream: "origin/main",
+		Date:     time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if v != expected {
+		t.Errorf("unexpected version: %+v", v)
+	}
+}
+
+// versionLogger records the version it's sent.
+type versionLogger struct {
+	EmptyLogger
+	version *Version
+}
+
+func (v *versionLogger) Version(version Version) {
+	v.version = &version
+}
+
+func TestLogHelperVersion(t *testing.T) {
+	log := &versionLogger{EmptyLogger: EmptyLogger{Level: ErrorLevel + 1}}
+	d := LogHelper{log}
+	d.Start()
+
+	if log.version == nil || *log.version != BuildVersion() {
+		t.Errorf("expected the build version to be logged, got %v", log.version)
+	}
+}
@@ -2,6 +2,10 @@ package tugboat
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
 )
 
 type SystemError struct{}
@@ -9,59 +13,250 @@ type ExecError struct {
 	ExitCode int
 }
 
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("exit code %d", e.ExitCode)
+}
+
+// TimeoutError is returned when a task runs longer than Task.Timeout.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("task timed out after %s", e.Timeout)
+}
+
+// CanceledError is returned when the task's context is canceled
+// before the command finishes.
+type CanceledError struct{}
+
+func (e *CanceledError) Error() string {
+	return "task canceled"
+}
+
 type InvalidInputsError struct{}
 type InvalidOutputsError struct{}
 
 type File struct {
-	URL  string
-	Path string
+	URL  string `json:"url,omitempty" yaml:"url,omitempty"`
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// Size is the size of the file in bytes, if known.
+	// It's set on files passed to Logger after a transfer.
+	Size int64 `json:"size,omitempty" yaml:"size,omitempty"`
 }
 
 type Task struct {
-	ID             string
-	ContainerImage string
-	Command        []string
-	Env            map[string]string
+	ID             string            `json:"id,omitempty" yaml:"id,omitempty"`
+	ContainerImage string            `json:"containerImage,omitempty" yaml:"containerImage,omitempty"`
+	Command        []string          `json:"command,omitempty" yaml:"command,omitempty"`
+	Env            map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
 
-	Workdir string
+	Workdir string `json:"workdir,omitempty" yaml:"workdir,omitempty"`
 
-	Volumes []string
-	Inputs,
+	// User is the "uid:gid" the command runs as. When set, and the worker
+	// runs as root, the stage, with the downloaded inputs, is owned by this
+	// user while the command runs, and ownership is returned to the worker's
+	// user before outputs are uploaded. Otherwise the stage must be writable
+	// by the user. Defaults to the image's user.
+	User string `json:"user,omitempty" yaml:"user,omitempty"`
+
+	Volumes []string `json:"volumes,omitempty" yaml:"volumes,omitempty"`
+	Inputs  []File   `json:"inputs,omitempty" yaml:"inputs,omitempty"`
 	// All output paths must be contained in a volume.
-	Outputs []File
+	Outputs []File `json:"outputs,omitempty" yaml:"outputs,omitempty"`
+
+	Stdin  string `json:"stdin,omitempty" yaml:"stdin,omitempty"`
+	Stdout string `json:"stdout,omitempty" yaml:"stdout,omitempty"`
+	Stderr string `json:"stderr,omitempty" yaml:"stderr,omitempty"`
+
+	// Executors is a list of commands which run one after another,
+	// against the same staged inputs, outputs and volumes. When set,
+	// it replaces ContainerImage, Command and Stdin/Stdout/Stderr above.
+	Executors []TaskExecutor `json:"executors,omitempty" yaml:"executors,omitempty"`
 
-	Stdin, Stdout, Stderr string
+	// Services are background containers which run alongside the command,
+	// e.g. a database or a license server. They share the task's volumes
+	// and network.
+	Services []Service `json:"services,omitempty" yaml:"services,omitempty"`
+
+	// Network is the container's network mode: "none", "bridge",
+	// or the name of a custom network. Defaults to the executor's default.
+	Network string `json:"network,omitempty" yaml:"network,omitempty"`
+	// ExtraHosts are additional "hostname:ip" entries for /etc/hosts.
+	ExtraHosts []string `json:"extraHosts,omitempty" yaml:"extraHosts,omitempty"`
+	// DNS is a list of DNS servers for the container to use.
+	DNS []string `json:"dns,omitempty" yaml:"dns,omitempty"`
+	// Ports are container ports published on the host,
+	// in the form "[host:]container[/protocol]".
+	Ports []string `json:"ports,omitempty" yaml:"ports,omitempty"`
+
+	// Timeout limits the wall-clock time of the command.
+	// Outputs are still uploaded after a timeout. Zero means no timeout.
+	Timeout Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+
+	// Resources are the resources the task needs, used to decide
+	// how many tasks can run on a host at once.
+	Resources Resources `json:"resources,omitempty" yaml:"resources,omitempty"`
 }
 
+// Resources describes the resources a task needs, or a host has.
+// Zero means unspecified.
+type Resources struct {
+	CPUCores float64 `json:"cpuCores,omitempty" yaml:"cpuCores,omitempty"`
+	RAMGB    float64 `json:"ramGb,omitempty" yaml:"ramGb,omitempty"`
+	DiskGB   float64 `json:"diskGb,omitempty" yaml:"diskGb,omitempty"`
+}
+
+// TaskExecutor is one command of a multi-command task.
+type TaskExecutor struct {
+	ContainerImage string   `json:"containerImage,omitempty" yaml:"containerImage,omitempty"`
+	Command        []string `json:"command,omitempty" yaml:"command,omitempty"`
+	// Env is merged with, and overrides, the task's Env.
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	// Workdir defaults to the task's Workdir.
+	Workdir string `json:"workdir,omitempty" yaml:"workdir,omitempty"`
+
+	Stdin  string `json:"stdin,omitempty" yaml:"stdin,omitempty"`
+	Stdout string `json:"stdout,omitempty" yaml:"stdout,omitempty"`
+	Stderr string `json:"stderr,omitempty" yaml:"stderr,omitempty"`
+
+	// IgnoreError allows the following executors to run
+	// when this executor's command fails.
+	IgnoreError bool `json:"ignoreError,omitempty" yaml:"ignoreError,omitempty"`
+}
+
+// Service is a background container which is started and health checked
+// before the task's first command runs, and torn down after the last.
+type Service struct {
+	// Name is used as the service's hostname on the task's network.
+	Name           string            `json:"name,omitempty" yaml:"name,omitempty"`
+	ContainerImage string            `json:"containerImage,omitempty" yaml:"containerImage,omitempty"`
+	Command        []string          `json:"command,omitempty" yaml:"command,omitempty"`
+	Env            map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+
+	// HealthCheck is a command run in the service's container,
+	// repeatedly, until it succeeds. The task's command waits
+	// until all services are healthy.
+	HealthCheck []string `json:"healthCheck,omitempty" yaml:"healthCheck,omitempty"`
+	// HealthTimeout limits how long to wait for HealthCheck to succeed.
+	// Defaults to one minute.
+	HealthTimeout Duration `json:"healthTimeout,omitempty" yaml:"healthTimeout,omitempty"`
+}
+
+// TaskResult describes the outcome of running a task.
+type TaskResult struct {
+	// ExitCode is the exit code of the command, if it ran.
+	// For multi-command tasks, this is the exit code of the
+	// executor which stopped the chain, if any.
+	ExitCode int `json:"exitCode"`
+	// Resources is the resource usage of the command,
+	// as measured by the executor.
+	Resources ResourceUsage `json:"resources"`
+	// Stdout and Stderr hold the end of the command's output,
+	// truncated to keep the result small.
+	Stdout string `json:"stdout,omitempty"`
+	Stderr string `json:"stderr,omitempty"`
+	// Executors holds the result of each executor
+	// of a multi-command task, in order.
+	Executors []*TaskResult `json:"executors,omitempty"`
+}
+
+// resultTailSize is the number of bytes of stdout and stderr
+// kept in a TaskResult.
+const resultTailSize = 10 * 1024
+
+// ResourceUsage describes the resources used by a task's command.
+type ResourceUsage struct {
+	// PeakMemory is the highest memory usage observed, in bytes.
+	PeakMemory int64         `json:"peakMemory,omitempty"`
+	CPUTime    time.Duration `json:"cpuTime,omitempty"`
+	// BlockRead and BlockWrite are bytes read and written to block devices.
+	BlockRead  int64 `json:"blockRead,omitempty"`
+	BlockWrite int64 `json:"blockWrite,omitempty"`
+	// NetRx and NetTx are network bytes received and sent.
+	NetRx int64 `json:"netRx,omitempty"`
+	NetTx int64 `json:"netTx,omitempty"`
+}
+
+// Add accumulates the usage of o, e.g. for a sequence of commands.
+func (r *ResourceUsage) Add(o ResourceUsage) {
+	if o.PeakMemory > r.PeakMemory {
+		r.PeakMemory = o.PeakMemory
+	}
+	r.CPUTime += o.CPUTime
+	r.BlockRead += o.BlockRead
+	r.BlockWrite += o.BlockWrite
+	r.NetRx += o.NetRx
+	r.NetTx += o.NetTx
+}
+
+// Executor runs the command of a staged task.
+//
+// In a dry run (task.DryRun), Exec must not start anything.
+// Instead, it describes what it would run, e.g. with Logger.Meta.
 type Executor interface {
 	Exec(context.Context, *StagedTask, *Stdio) error
 }
 
-func Run(ctx context.Context, task *Task, stage *Stage, log Logger, store Storage, exec Executor) (err error) {
+// ServiceExecutor is an Executor which runs Task.Services.
+// Run starts the services once, before the first command of the task,
+// and stops them after the last. Exec doesn't start them.
+type ServiceExecutor interface {
+	Executor
+	// StartServices starts the task's services and waits until they're
+	// healthy. It may set task.Network, to the network the commands share
+	// with the services. The returned stop function tears the services down,
+	// and must be called even when an error is returned.
+	//
+	// In a dry run, StartServices describes the services instead.
+	StartServices(ctx context.Context, task *StagedTask) (stop func(), err error)
+}
+
+func Run(ctx context.Context, task *Task, stage *Stage, log Logger, store Storage, exec Executor) (result *TaskResult, err error) {
 
+	result = &TaskResult{}
 	var me MultiError
 	try := me.Try
 	defer func() {
 		err = me.Finish()
 	}()
 
-	info := log.Info
 	d := LogHelper{log}
 	d.Start()
-	defer d.Finish()
+	defer func() {
+		// Report the outcome of the task before the end time.
+		err := me.Finish()
+		state := FinalState(err)
+		if err != nil {
+			// Errors caused by cancelation, e.g. an interrupted
+			// download, still mean the task was canceled.
+			if ctx.Err() != nil {
+				state = Canceled
+			}
+			log.Error("task failed", "task", task.ID, "state", state, "error", err)
+		}
+		log.Finished(state, err)
+		d.Finish()
+	}()
 
 	// TODO
-	//info("validating task")
+	//log.Info("validating task")
 	//err = store.Validate(ctx, task.Outputs)
 	//Must(err)
 
-	info("creating staging directory")
+	log.Debug("creating staging directory", "task", task.ID)
 	var staged *StagedTask
 	staged, err = StageTask(stage, task)
 	try(err)
 	if err != nil {
 		return
 	}
+	staged.Result = result
+
+	if stage.DryRun {
+		try(dryRun(ctx, staged, log, exec))
+		return
+	}
 
 	defer func() {
 		try(staged.RemoveAll())
@@ -77,20 +272,183 @@ func Run(ctx context.Context, task *Task, stage *Stage, log Logger, store Storag
 		try(Upload(ctx, staged, store, log))
 	}()
 
-	var stdio *Stdio
-	stdio, err = DefaultStdio(staged, log)
+	// Give the stage to task.User while the command runs. Files left
+	// behind by the command are owned by task.User, so reclaim them
+	// before they're uploaded and cleaned up.
+	owned, err := staged.chownUser(log)
 	try(err)
 	if err != nil {
 		return
 	}
+	if owned {
+		defer func() {
+			try(staged.Chown(os.Getuid(), os.Getgid()))
+		}()
+	}
+
+	defer log.Debug("cleaning up", "task", task.ID)
+
+	execCtx := ctx
+	if task.Timeout > 0 {
+		var cancel context.CancelFunc
+		execCtx, cancel = context.WithTimeout(ctx, time.Duration(task.Timeout))
+		defer cancel()
+	}
+
+	// Services are stopped before outputs are uploaded.
+	stopServices, err := startServices(execCtx, staged, exec)
+	defer stopServices()
+	if err != nil {
+		try(execError(ctx, execCtx, task, err))
+		return
+	}
+
+	// A single-command task is run as a task with one step.
+	steps := staged.Steps
+	if len(steps) == 0 {
+		steps = []*StagedTask{staged}
+	}
+
+	for i, step := range steps {
+		if step != staged {
+			step.Result = &TaskResult{}
+			result.Executors = append(result.Executors, step.Result)
+		}
+
+		log.Running()
+		err = runStep(execCtx, step, log, exec)
+		log.Exited(exitCode(err))
+
+		if step != staged {
+			result.Resources.Add(step.Result.Resources)
+			result.Stdout = step.Result.Stdout
+			result.Stderr = step.Result.Stderr
+		}
+		code := 0
+		if e, ok := err.(*ExecError); ok {
+			code = e.ExitCode
+			step.Result.ExitCode = code
+		}
+
+		if err == nil {
+			continue
+		}
+		if code != 0 && i < len(task.Executors) && task.Executors[i].IgnoreError {
+			log.Warn("executor failed, continuing", "executor", i, "error", err)
+			continue
+		}
+		result.ExitCode = code
+		try(execError(ctx, execCtx, task, err))
+		break
+	}
+
+	return
+}
+
+// dryRun logs the staging plan of a task: the host path of each input,
+// output and volume. Then it asks the executor to describe each command,
+// without transferring files or running anything.
+func dryRun(ctx context.Context, staged *StagedTask, log Logger, exec Executor) error {
+	log.Info("dry run", "task", staged.ID, "stage", staged.Dir)
+
+	for i, in := range staged.Inputs {
+		log.Info("input", "url", in.URL, "host", in.Path, "container", staged.Task.Inputs[i].Path)
+	}
+	for i, out := range staged.Outputs {
+		log.Info("output", "url", out.URL, "host", out.Path, "container", staged.Task.Outputs[i].Path)
+	}
+	for i, vol := range staged.Volumes {
+		log.Info("volume", "host", vol, "container", staged.Task.Volumes[i])
+	}
+
+	stop, err := startServices(ctx, staged, exec)
+	defer stop()
+	if err != nil {
+		return err
+	}
+
+	steps := staged.Steps
+	if len(steps) == 0 {
+		steps = []*StagedTask{staged}
+	}
+	for _, step := range steps {
+		err := exec.Exec(ctx, step, &Stdio{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startServices starts the task's services, if it has any and the executor
+// runs them, on behalf of all of the task's commands.
+func startServices(ctx context.Context, staged *StagedTask, exec Executor) (func(), error) {
+	se, ok := exec.(ServiceExecutor)
+	if !ok || len(staged.Services) == 0 {
+		return func() {}, nil
+	}
+	stop, err := se.StartServices(ctx, staged)
+	// The steps join the network of the services.
+	for _, step := range staged.Steps {
+		step.Network = staged.Network
+	}
+	return stop, err
+}
+
+// exitCode returns the exit code for an error returned by an executor.
+// Errors other than ExecError, e.g. a failure to start the container,
+// are reported as -1.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if e, ok := err.(*ExecError); ok {
+		return e.ExitCode
+	}
+	return -1
+}
+
+// runStep runs one command of the task, with its own stdio.
+func runStep(ctx context.Context, step *StagedTask, log Logger, exec Executor) (err error) {
+	var stdio *Stdio
+	stdio, err = DefaultStdio(step, log)
+	if err != nil {
+		return err
+	}
 
 	defer func() {
-		try(stdio.Close())
+		cerr := stdio.Close()
+		if err == nil {
+			err = cerr
+		}
 	}()
-	defer info("cleaning up")
 
-	log.Running()
-	try(exec.Exec(ctx, staged, stdio))
+	// Keep the end of the output for the task result.
+	stdout := NewTailBuffer(resultTailSize)
+	stderr := NewTailBuffer(resultTailSize)
+	stdio.Stdout = io.MultiWriter(stdio.Stdout, stdout)
+	stdio.Stderr = io.MultiWriter(stdio.Stderr, stderr)
+	defer func() {
+		if step.Result != nil {
+			step.Result.Stdout = stdout.String()
+			step.Result.Stderr = stderr.String()
+		}
+	}()
 
-	return
+	return exec.Exec(ctx, step, stdio)
+}
+
+// execError distinguishes a timeout or cancellation from
+// a failure of the command itself.
+func execError(ctx, execCtx context.Context, task *Task, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return &CanceledError{}
+	}
+	if execCtx.Err() == context.DeadlineExceeded {
+		return &TimeoutError{time.Duration(task.Timeout)}
+	}
+	return err
 }
@@ -0,0 +1,246 @@
+// Package dirqueue implements a worker task source backed by
+// directories on a local (or shared) filesystem.
+//
+// Tasks are task files, as read by package taskfile.
+// A queue directory holds:
+//
+//	queued/    task files waiting to run
+//	running/   task files claimed by a worker
+//	complete/  task files which finished successfully
+//	failed/    task files which finished in any other state
+//	state/     a JSON status file per task ID, updated on each transition
+//
+// To submit a task, write its file into queued/. Write it elsewhere first
+// and rename it into place, so workers don't read a partial file.
+// Tasks are claimed by renaming them into running/, so several workers
+// may share a queue directory. Task files left in running/ by a worker
+// which crashed can be requeued with Recover.
+package dirqueue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	"github.com/buchanae/tugboat/taskfile"
+)
+
+// Status is the content of a task's status file.
+type Status struct {
+	ID     string          `json:"id"`
+	File   string          `json:"file"`
+	State  tug.State       `json:"state"`
+	Time   time.Time       `json:"time"`
+	Error  string          `json:"error,omitempty"`
+	Result *tug.TaskResult `json:"result,omitempty"`
+}
+
+// Queue is a directory of task files.
+type Queue struct {
+	Dir string
+
+	mu sync.Mutex
+	// claimed maps the IDs of claimed tasks to their file names.
+	claimed map[string]string
+}
+
+// New returns a queue in dir, creating its subdirectories.
+func New(dir string) (*Queue, error) {
+	for _, sub := range []string{"queued", "running", "complete", "failed", "state"} {
+		err := tug.EnsureDir(filepath.Join(dir, sub), 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Queue{Dir: dir, claimed: map[string]string{}}, nil
+}
+
+// Recover moves the task files left in running/, e.g. by a worker which
+// crashed, back to queued/, and returns their names. Files claimed by this
+// queue are left alone, but files claimed by other workers aren't, so only
+// call Recover when no other worker is using the queue directory.
+func (q *Queue) Recover() ([]string, error) {
+	entries, err := os.ReadDir(q.path("running"))
+	if err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	claimed := map[string]bool{}
+	for _, name := range q.claimed {
+		claimed[name] = true
+	}
+	q.mu.Unlock()
+
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || claimed[name] {
+			continue
+		}
+		queued := q.path("queued", name)
+		if err := os.Rename(q.path("running", name), queued); err != nil {
+			return names, err
+		}
+		names = append(names, name)
+
+		// Files which can't be loaded are rejected by Next.
+		if task, err := taskfile.Load(queued, nil); err == nil {
+			q.writeStatus(&Status{
+				ID:    task.ID,
+				File:  name,
+				State: tug.Queued,
+				Time:  time.Now(),
+				Error: "requeued after the worker running it stopped",
+			})
+		}
+	}
+	return names, nil
+}
+
+// Next claims the oldest task file in queued/, by name.
+// Files which can't be loaded, and tasks whose ID is already claimed,
+// are moved to failed/, with the error in their status file. Two tasks
+// with the same ID would share a stage directory and a status file.
+func (q *Queue) Next(ctx context.Context) (*tug.Task, error) {
+	entries, err := os.ReadDir(q.path("queued"))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		running := q.path("running", name)
+		err := os.Rename(q.path("queued", name), running)
+		if os.IsNotExist(err) {
+			// Claimed by another worker.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		task, err := taskfile.Load(running, nil)
+		if err != nil {
+			q.reject(name, err)
+			continue
+		}
+
+		q.mu.Lock()
+		prev, dup := q.claimed[task.ID]
+		if !dup {
+			q.claimed[task.ID] = name
+		}
+		q.mu.Unlock()
+
+		if dup {
+			q.reject(name, fmt.Errorf("task ID %q is already in use by %s", task.ID, prev))
+			continue
+		}
+		return task, nil
+	}
+	return nil, nil
+}
+
+// Update writes the task's status file. In a final state,
+// the task file is moved to complete/ or failed/.
+func (q *Queue) Update(ctx context.Context, id string, state tug.State, result *tug.TaskResult, err error) error {
+	q.mu.Lock()
+	name, ok := q.claimed[id]
+	if ok && state.Terminal() {
+		delete(q.claimed, id)
+	}
+	q.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("task %s is not claimed", id)
+	}
+
+	status := &Status{ID: id, File: name, State: state, Time: time.Now(), Result: result}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	if werr := q.writeStatus(status); werr != nil {
+		return werr
+	}
+
+	if !state.Terminal() {
+		return nil
+	}
+	dest := "failed"
+	if state == tug.Complete {
+		dest = "complete"
+	}
+	return os.Rename(q.path("running", name), q.path(dest, name))
+}
+
+// Status returns the status of a task, or nil if the task
+// has no status file.
+func (q *Queue) Status(id string) (*Status, error) {
+	data, err := os.ReadFile(q.statusPath(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	status := &Status{}
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// reject moves a task file which can't be run to failed/. The status
+// file is named after the file, since the task's ID is unknown, or
+// belongs to another task.
+func (q *Queue) reject(name string, err error) {
+	q.writeStatus(&Status{
+		ID:    name,
+		File:  name,
+		State: tug.SystemErrorState,
+		Time:  time.Now(),
+		Error: err.Error(),
+	})
+	os.Rename(q.path("running", name), q.path("failed", name))
+}
+
+// writeStatus writes a status file atomically, by renaming a temporary file.
+func (q *Queue) writeStatus(status *Status) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := q.statusPath(status.ID)
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (q *Queue) statusPath(id string) string {
+	return q.path("state", filepath.Base(id)+".json")
+}
+
+func (q *Queue) path(parts ...string) string {
+	return filepath.Join(append([]string{q.Dir}, parts...)...)
+}
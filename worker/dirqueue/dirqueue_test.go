@@ -0,0 +1,125 @@
+package dirqueue
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	tug "github.com/buchanae/tugboat"
+)
+
+func writeTask(t *testing.T, path, id string) {
+	content := "{id: " + id + ", containerImage: alpine, command: [echo, hello]}"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	q, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A worker crashed while running these tasks.
+	writeTask(t, q.path("running", "a.yaml"), "a")
+	writeTask(t, q.path("running", "b.yaml"), "b")
+	names, err := q.Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"a.yaml", "b.yaml"}) {
+		t.Errorf("unexpected requeued tasks: %v", names)
+	}
+
+	status, err := q.Status("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || status.State != tug.Queued {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	task, err := q.Next(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task == nil || task.ID != "a" {
+		t.Fatalf("expected the requeued task, got %+v", task)
+	}
+
+	// Tasks claimed by this queue aren't requeued.
+	writeTask(t, q.path("running", "c.yaml"), "c")
+	names, err = q.Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"c.yaml"}) {
+		t.Errorf("unexpected requeued tasks: %v", names)
+	}
+	if _, err := os.Stat(q.path("running", "a.yaml")); err != nil {
+		t.Errorf("expected the claimed task to stay in running/: %v", err)
+	}
+}
+
+func TestNextCanceled(t *testing.T) {
+	q, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTask(t, q.path("queued", "a.yaml"), "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := q.Next(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if _, err := os.Stat(q.path("queued", "a.yaml")); err != nil {
+		t.Errorf("expected the task to stay queued: %v", err)
+	}
+}
+
+func TestNextDuplicateID(t *testing.T) {
+	q, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTask(t, q.path("queued", "a.yaml"), "dup")
+	writeTask(t, q.path("queued", "b.yaml"), "dup")
+
+	task, err := q.Next(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task == nil || task.ID != "dup" {
+		t.Fatalf("expected the first task, got %+v", task)
+	}
+
+	// The second task is rejected while the first is claimed.
+	task, err = q.Next(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task != nil {
+		t.Fatalf("expected no task, got %+v", task)
+	}
+	if _, err := os.Stat(q.path("failed", "b.yaml")); err != nil {
+		t.Errorf("expected the duplicate to be moved to failed/: %v", err)
+	}
+	status, err := q.Status("b.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || status.State != tug.SystemErrorState || status.Error == "" {
+		t.Errorf("unexpected status of the duplicate: %+v", status)
+	}
+
+	// The first task is unaffected.
+	if err := q.Update(context.Background(), "dup", tug.Complete, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(q.path("complete", "a.yaml")); err != nil {
+		t.Errorf("expected the first task to complete: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+// Package worker runs tasks from a queue, as a long-running node agent.
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+)
+
+// Source is a queue of tasks for a worker.
+type Source interface {
+	// Next claims the next task to run,
+	// or returns nil if no task is available.
+	Next(ctx context.Context) (*tug.Task, error)
+
+	// Update reports a state transition of a claimed task.
+	// In a final state, result is the task's result, and err is
+	// the error returned by Run, if any.
+	Update(ctx context.Context, id string, state tug.State, result *tug.TaskResult, err error) error
+}
+
+// Worker claims tasks from a Source and runs them through tugboat.Run.
+type Worker struct {
+	Source  Source
+	Stage   *tug.Stage
+	Storage tug.Storage
+
+	// Log receives the worker's own events, e.g. errors from the source.
+	Log tug.Logger
+	// NewLogger returns the logger for a task.
+	NewLogger func(task *tug.Task) (tug.Logger, error)
+	// NewExecutor returns the executor for a task, logging to log.
+	NewExecutor func(log tug.Logger) (tug.Executor, error)
+
+	// Concurrency is the maximum number of tasks running at once.
+	// Defaults to one.
+	Concurrency int
+	// PollInterval is how long to wait before asking the source again,
+	// when it has no tasks. Defaults to one second.
+	PollInterval time.Duration
+
+	initOnce  sync.Once
+	drainOnce sync.Once
+	draining  chan struct{}
+}
+
+// Run claims and runs tasks until ctx is canceled or Drain is called,
+// then waits for the running tasks to finish. Canceling ctx
+// cancels the running tasks.
+func (w *Worker) Run(ctx context.Context) error {
+	w.init()
+
+	concurrency := w.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	interval := w.PollInterval
+	if interval == 0 {
+		interval = time.Second
+	}
+
+	slots := make(chan struct{}, concurrency)
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		case <-w.draining:
+			return nil
+		}
+
+		task, err := w.next(ctx)
+		if err != nil {
+			w.Log.Error("failed to get next task", "error", err)
+		}
+		if task == nil {
+			<-slots
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return nil
+			case <-w.draining:
+				return nil
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			w.run(ctx, task)
+		}()
+	}
+}
+
+// Drain stops the worker from claiming new tasks.
+// Run returns once the running tasks finish.
+func (w *Worker) Drain() {
+	w.init()
+	w.drainOnce.Do(func() {
+		close(w.draining)
+	})
+}
+
+func (w *Worker) init() {
+	w.initOnce.Do(func() {
+		w.draining = make(chan struct{})
+	})
+}
+
+// next claims the next task, unless the worker is draining.
+func (w *Worker) next(ctx context.Context) (*tug.Task, error) {
+	select {
+	case <-w.draining:
+		return nil, nil
+	default:
+	}
+	return w.Source.Next(ctx)
+}
+
+func (w *Worker) run(ctx context.Context, task *tug.Task) {
+	w.update(task.ID, tug.Initializing, nil, nil)
+
+	result, state, err := w.runTask(ctx, task)
+
+	w.Log.Info("task finished", "task", task.ID, "state", state)
+	w.update(task.ID, state, result, err)
+}
+
+// runTask runs a task, returning its result and final state.
+func (w *Worker) runTask(ctx context.Context, task *tug.Task) (*tug.TaskResult, tug.State, error) {
+	log, err := w.NewLogger(task)
+	if err != nil {
+		return nil, tug.FinalState(err), err
+	}
+	exec, err := w.NewExecutor(log)
+	if err != nil {
+		return nil, tug.FinalState(err), err
+	}
+
+	// Report the running state when the first command starts.
+	// The final state is reported with the result.
+	sl := tug.NewStateLogger(log, func(from, to tug.State) {
+		if to == tug.Running {
+			w.update(task.ID, tug.Running, nil, nil)
+		}
+	})
+	result, err := tug.Run(ctx, task, w.Stage, sl, w.Storage, exec)
+	return result, sl.State(), err
+}
+
+// update reports a state transition to the source. Updates are sent
+// even when the worker's context is canceled, so the source learns
+// that tasks were canceled.
+func (w *Worker) update(id string, state tug.State, result *tug.TaskResult, err error) {
+	uerr := w.Source.Update(context.Background(), id, state, result, err)
+	if uerr != nil {
+		w.Log.Error("failed to update task state", "task", id, "state", state, "error", uerr)
+	}
+}
@@ -0,0 +1,160 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tug "github.com/buchanae/tugboat"
+	"github.com/buchanae/tugboat/storage/local"
+	"github.com/buchanae/tugboat/worker/dirqueue"
+)
+
+// fakeExec fails tasks whose command is "fail",
+// and blocks tasks whose command is "block" until released.
+type fakeExec struct {
+	started chan string
+	release chan struct{}
+}
+
+func (f *fakeExec) Exec(ctx context.Context, task *tug.StagedTask, stdio *tug.Stdio) error {
+	f.started <- task.ID
+	switch task.Command[0] {
+	case "fail":
+		return &tug.ExecError{ExitCode: 1}
+	case "block":
+		<-f.release
+	}
+	return nil
+}
+
+func newWorker(t *testing.T, q *dirqueue.Queue, exec *fakeExec) *Worker {
+	stage, err := tug.NewStage(t.TempDir(), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quiet := tug.EmptyLogger{Level: tug.ErrorLevel + 1}
+	return &Worker{
+		Source:  q,
+		Stage:   stage,
+		Storage: &local.Local{},
+		Log:     quiet,
+		NewLogger: func(*tug.Task) (tug.Logger, error) {
+			return quiet, nil
+		},
+		NewExecutor: func(tug.Logger) (tug.Executor, error) {
+			return exec, nil
+		},
+		PollInterval: 10 * time.Millisecond,
+	}
+}
+
+func submit(t *testing.T, q *dirqueue.Queue, name, content string) {
+	err := os.WriteFile(filepath.Join(q.Dir, "queued", name), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWorker(t *testing.T) {
+	q, err := dirqueue.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	submit(t, q, "1.yaml", "{id: ok, containerImage: alpine, command: [ok]}")
+	submit(t, q, "2.yaml", "{id: fail, containerImage: alpine, command: [fail]}")
+	submit(t, q, "3.yaml", "{id: invalid, containerImage: alpine}")
+
+	exec := &fakeExec{started: make(chan string, 10)}
+	w := newWorker(t, q, exec)
+	w.Concurrency = 2
+
+	done := make(chan error)
+	go func() {
+		done <- w.Run(context.Background())
+	}()
+
+	// Wait for both valid tasks to start, then for their files to move.
+	<-exec.started
+	<-exec.started
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		running, _ := os.ReadDir(filepath.Join(q.Dir, "running"))
+		queued, _ := os.ReadDir(filepath.Join(q.Dir, "queued"))
+		if len(running) == 0 && len(queued) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for tasks to finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	w.Drain()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]tug.State{
+		"ok":     tug.Complete,
+		"fail":   tug.ExecutorError,
+		"3.yaml": tug.SystemErrorState,
+	}
+	for id, state := range expected {
+		status, err := q.Status(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status == nil || status.State != state {
+			t.Errorf("expected %s to be %s, got %+v", id, state, status)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(q.Dir, "complete", "1.yaml")); err != nil {
+		t.Errorf("expected 1.yaml to be complete: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(q.Dir, "failed", "2.yaml")); err != nil {
+		t.Errorf("expected 2.yaml to be failed: %s", err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	q, err := dirqueue.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	submit(t, q, "1.yaml", "{id: block, containerImage: alpine, command: [block]}")
+	submit(t, q, "2.yaml", "{id: next, containerImage: alpine, command: [ok]}")
+
+	exec := &fakeExec{started: make(chan string, 10), release: make(chan struct{})}
+	w := newWorker(t, q, exec)
+
+	done := make(chan error)
+	go func() {
+		done <- w.Run(context.Background())
+	}()
+
+	<-exec.started
+	w.Drain()
+
+	select {
+	case <-done:
+		t.Fatal("expected Run to wait for the running task")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(exec.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	status, _ := q.Status("block")
+	if status == nil || status.State != tug.Complete {
+		t.Errorf("expected the running task to complete, got %+v", status)
+	}
+	if _, err := os.Stat(filepath.Join(q.Dir, "queued", "2.yaml")); err != nil {
+		t.Errorf("expected the queued task to stay queued: %s", err)
+	}
+}
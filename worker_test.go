@@ -0,0 +1,216 @@
+package tugboat
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// blockExec blocks until the context is done, after signaling started.
+type blockExec struct {
+	started chan struct{}
+}
+
+func (b *blockExec) Exec(ctx context.Context, task *StagedTask, stdio *Stdio) error {
+	close(b.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// stepExec runs each command by its name: it writes the name to stdout,
+// reports usage of one second of CPU and the command's index in MB of
+// memory, and exits with the code in exits, if any.
+type stepExec struct {
+	exits map[string]int
+	ran   []string
+}
+
+func (s *stepExec) Exec(ctx context.Context, task *StagedTask, stdio *Stdio) error {
+	name := task.Command[0]
+	s.ran = append(s.ran, name)
+	fmt.Fprint(stdio.Stdout, name)
+	task.Result.Resources = ResourceUsage{
+		CPUTime:    time.Second,
+		PeakMemory: int64(len(s.ran)) << 20,
+	}
+	if code := s.exits[name]; code != 0 {
+		return &ExecError{ExitCode: code}
+	}
+	return nil
+}
+
+func runTest(t *testing.T, ctx context.Context, task *Task, exec Executor) (*TaskResult, error) {
+	stage, err := NewStage(t.TempDir(), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := EmptyLogger{Level: ErrorLevel + 1}
+	return Run(ctx, task, stage, log, nil, exec)
+}
+
+// only returns the single error of a MultiError.
+func only(t *testing.T, err error) error {
+	errs, ok := err.(MultiError)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("expected a single error, got %v", err)
+	}
+	return errs[0]
+}
+
+func TestRunTimeout(t *testing.T) {
+	task := &Task{ID: "timeout", Command: []string{"sleep"}, Timeout: Duration(10 * time.Millisecond)}
+	_, err := runTest(t, context.Background(), task, &blockExec{started: make(chan struct{})})
+
+	timeout, ok := only(t, err).(*TimeoutError)
+	if !ok {
+		t.Fatalf("expected a TimeoutError, got %v", err)
+	}
+	if timeout.Timeout != 10*time.Millisecond {
+		t.Errorf("unexpected timeout: %s", timeout.Timeout)
+	}
+	if FinalState(err) != ExecutorError {
+		t.Errorf("unexpected state: %s", FinalState(err))
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	exec := &blockExec{started: make(chan struct{})}
+	go func() {
+		<-exec.started
+		cancel()
+	}()
+
+	// The timeout doesn't hide the cancelation.
+	task := &Task{ID: "cancel", Command: []string{"sleep"}, Timeout: Duration(time.Hour)}
+	_, err := runTest(t, ctx, task, exec)
+
+	if _, ok := only(t, err).(*CanceledError); !ok {
+		t.Fatalf("expected a CanceledError, got %v", err)
+	}
+	if FinalState(err) != Canceled {
+		t.Errorf("unexpected state: %s", FinalState(err))
+	}
+}
+
+func TestExecError(t *testing.T) {
+	background := context.Background()
+	canceled, cancel := context.WithCancel(background)
+	cancel()
+	expired, cancel := context.WithTimeout(background, -time.Second)
+	defer cancel()
+
+	task := &Task{Timeout: Duration(time.Minute)}
+	exit := &ExecError{ExitCode: 2}
+
+	tests := []struct {
+		name         string
+		ctx, execCtx context.Context
+		err          error
+		expected     error
+	}{
+		{"success", background, background, nil, nil},
+		{"exit code", background, background, exit, exit},
+		{"timeout", background, expired, exit, &TimeoutError{time.Minute}},
+		{"canceled", canceled, canceled, exit, &CanceledError{}},
+		{"canceled and expired", canceled, expired, exit, &CanceledError{}},
+	}
+	for _, test := range tests {
+		err := execError(test.ctx, test.execCtx, task, test.err)
+		if test.expected == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.expected.Error() {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+}
+
+func TestRunSteps(t *testing.T) {
+	tests := []struct {
+		name   string
+		ignore bool
+		exits  map[string]int
+		// ran lists the commands which ran, and codes their exit codes.
+		ran   []string
+		codes []int
+		// exitCode is the exit code of the task, or 0 if it succeeded.
+		exitCode int
+	}{
+		{
+			name:  "success",
+			ran:   []string{"one", "two", "three"},
+			codes: []int{0, 0, 0},
+		},
+		{
+			name:     "failed step",
+			exits:    map[string]int{"two": 3},
+			ran:      []string{"one", "two"},
+			codes:    []int{0, 3},
+			exitCode: 3,
+		},
+		{
+			name:   "ignored error",
+			ignore: true,
+			exits:  map[string]int{"two": 3},
+			ran:    []string{"one", "two", "three"},
+			codes:  []int{0, 3, 0},
+		},
+	}
+	for _, test := range tests {
+		task := &Task{
+			ID: "steps",
+			Executors: []TaskExecutor{
+				{ContainerImage: "alpine", Command: []string{"one"}},
+				{ContainerImage: "alpine", Command: []string{"two"}, IgnoreError: test.ignore},
+				{ContainerImage: "alpine", Command: []string{"three"}},
+			},
+		}
+		exec := &stepExec{exits: test.exits}
+		result, err := runTest(t, context.Background(), task, exec)
+
+		if !reflect.DeepEqual(exec.ran, test.ran) {
+			t.Errorf("%s: unexpected commands: %q", test.name, exec.ran)
+		}
+		if test.exitCode == 0 && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if test.exitCode != 0 {
+			if e, ok := only(t, err).(*ExecError); !ok || e.ExitCode != test.exitCode {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+		}
+		if result.ExitCode != test.exitCode {
+			t.Errorf("%s: unexpected exit code: %d", test.name, result.ExitCode)
+		}
+
+		var codes []int
+		for _, r := range result.Executors {
+			codes = append(codes, r.ExitCode)
+		}
+		if !reflect.DeepEqual(codes, test.codes) {
+			t.Errorf("%s: unexpected executor exit codes: %v", test.name, codes)
+		}
+		for i, r := range result.Executors {
+			if r.Stdout != test.ran[i] {
+				t.Errorf("%s: unexpected stdout of executor %d: %q", test.name, i, r.Stdout)
+			}
+		}
+
+		// CPU time is summed and peak memory is the highest of any step.
+		n := len(test.ran)
+		expected := ResourceUsage{CPUTime: time.Duration(n) * time.Second, PeakMemory: int64(n) << 20}
+		if result.Resources != expected {
+			t.Errorf("%s: unexpected resources: %+v", test.name, result.Resources)
+		}
+		if last := test.ran[n-1]; result.Stdout != last {
+			t.Errorf("%s: unexpected stdout: %q", test.name, result.Stdout)
+		}
+	}
+}